@@ -3,50 +3,142 @@ package main
 import (
 	"github.com/gin-gonic/gin"
 	"github.com/apeli23/infinity/controllers"
+	"github.com/apeli23/infinity/models"
 )
 
 var basePath = "/api/v2"
 
+// Route is a handler together with the token roles allowed to call it and the scopes the token must hold.
+// Routes without roles are open to anyone that gets past ValidateToken, which is everyone on `/public/` paths.
+// RateLimited routes call the SDP and count against the partner's rate limit and daily quota.
+type Route struct {
+	Handler     gin.HandlerFunc
+	Roles       []string
+	Scopes      []string
+	RateLimited bool
+}
+
+var (
+	adminOnly    = []string{models.RoleAdmin}
+	partnerOnly  = []string{models.RolePartner}
+	partnerAdmin = []string{models.RolePartner, models.RoleAdmin}
+	readers      = []string{models.RolePartner, models.RoleReadOnly, models.RoleAdmin}
+)
+
 // Routes Function to route mapping
-var Routes = map[string]map[string]gin.HandlerFunc{
+var Routes = map[string]map[string]Route{
 	basePath + "/partners": {
-		"GET": controllers.GetAllPartners,
+		"GET": {Handler: controllers.GetAllPartners, Roles: adminOnly, Scopes: []string{models.ScopePartnersAdmin}},
 	},
 	basePath + "/partner/add": {
-		"POST": controllers.CreatePartner,
+		"POST": {Handler: controllers.CreatePartner, Roles: adminOnly, Scopes: []string{models.ScopePartnersAdmin}},
+	},
+	basePath + "/partners/:id/reset-token": {
+		"POST": {Handler: controllers.IssuePartnerResetToken, Roles: adminOnly, Scopes: []string{models.ScopePartnersAdmin}},
+	},
+	basePath + "/partners/:id/rate-limits": {
+		"GET": {Handler: controllers.ListRateLimits, Roles: adminOnly, Scopes: []string{models.ScopePartnersAdmin}},
+		"PUT": {Handler: controllers.SetRateLimit, Roles: adminOnly, Scopes: []string{models.ScopePartnersAdmin}},
+	},
+	basePath + "/login/unlock": {
+		"POST": {Handler: controllers.UnlockLogin, Roles: adminOnly, Scopes: []string{models.ScopePartnersAdmin}},
+	},
+	basePath + "/partner/secret": {
+		"POST": {Handler: controllers.RotatePartnerSecret, Roles: partnerOnly, Scopes: []string{models.ScopeCredentialsWrite}},
+	},
+	"/public/v2/partner/secret/reset": {
+		"POST": {Handler: controllers.ResetPartnerSecret},
+	},
+	basePath + "/partner/webhook-secret": {
+		"POST": {Handler: controllers.RotateWebhookSecret, Roles: partnerOnly, Scopes: []string{models.ScopeWebhooksWrite}},
+	},
+	basePath + "/partner/users": {
+		"GET":  {Handler: controllers.ListPartnerUsers, Roles: readers, Scopes: []string{models.ScopeUsersRead}},
+		"POST": {Handler: controllers.InviteUser, Roles: partnerOnly, Scopes: []string{models.ScopeUsersWrite}},
+	},
+	basePath + "/partner/users/:id": {
+		"DELETE": {Handler: controllers.DeactivatePartnerUser, Roles: partnerOnly, Scopes: []string{models.ScopeUsersWrite}},
+	},
+	basePath + "/partner/api-keys": {
+		"GET":  {Handler: controllers.ListAPIKeys, Roles: partnerOnly, Scopes: []string{models.ScopeAPIKeysWrite}},
+		"POST": {Handler: controllers.CreateAPIKey, Roles: partnerOnly, Scopes: []string{models.ScopeAPIKeysWrite}},
+	},
+	basePath + "/partner/api-keys/:id": {
+		"DELETE": {Handler: controllers.RevokeAPIKey, Roles: partnerOnly, Scopes: []string{models.ScopeAPIKeysWrite}},
+	},
+	"/public/v2/user/invite/accept": {
+		"POST": {Handler: controllers.AcceptInvite},
 	},
 	"/public/v2/partner/token": {
-		"POST": controllers.GetPartnerToken,
+		"POST": {Handler: controllers.GetPartnerToken},
+	},
+	"/public/.well-known/jwks.json": {
+		"GET": {Handler: controllers.GetJWKS},
+	},
+	"/public/v2/token/refresh": {
+		"POST": {Handler: controllers.RefreshToken},
+	},
+	basePath + "/token/revoke": {
+		"POST": {Handler: controllers.RevokeToken},
+	},
+	"/public/v2/user/token": {
+		"POST": {Handler: controllers.GetUserToken},
 	},
 	basePath + "/ussd/activation": {
-		"POST": controllers.ActivateSubscriber,
+		"POST": {Handler: controllers.ActivateSubscriber, Roles: partnerOnly, Scopes: []string{models.ScopeSubscriptionsWrite}, RateLimited: true},
 	},
 	basePath + "/web/activation": {
-		"POST": controllers.WebActivateSubscriber,
+		"POST": {Handler: controllers.WebActivateSubscriber, Roles: partnerOnly, Scopes: []string{models.ScopeSubscriptionsWrite}, RateLimited: true},
 	},
 	basePath + "/ussd/deactivation": {
-		"POST": controllers.DeActivateSubscriber,
+		"POST": {Handler: controllers.DeActivateSubscriber, Roles: partnerOnly, Scopes: []string{models.ScopeSubscriptionsWrite}, RateLimited: true},
 	},
 	basePath + "/ussd/charge": {
-		"POST": controllers.ChargeSubscriber,
+		"POST": {Handler: controllers.ChargeSubscriber, Roles: partnerOnly, Scopes: []string{models.ScopeChargeWrite}, RateLimited: true},
+	},
+	basePath + "/plans": {
+		"GET":  {Handler: controllers.ListPlans, Roles: readers, Scopes: []string{models.ScopePlansRead}},
+		"POST": {Handler: controllers.CreatePlan, Roles: partnerAdmin, Scopes: []string{models.ScopePlansWrite}},
+	},
+	basePath + "/plans/:id": {
+		"PUT":    {Handler: controllers.UpdatePlan, Roles: partnerAdmin, Scopes: []string{models.ScopePlansWrite}},
+		"DELETE": {Handler: controllers.ArchivePlan, Roles: partnerAdmin, Scopes: []string{models.ScopePlansWrite}},
+	},
+	basePath + "/subscriptions": {
+		"GET": {Handler: controllers.ListSubscriptions, Roles: readers, Scopes: []string{models.ScopeSubscriptionsRead}},
+	},
+	basePath + "/subscriptions/:external_id": {
+		"GET": {Handler: controllers.GetSubscription, Roles: readers, Scopes: []string{models.ScopeSubscriptionsRead}},
+	},
+	basePath + "/transactions": {
+		"GET": {Handler: controllers.ListTransactions, Roles: readers, Scopes: []string{models.ScopeTransactionsRead}},
+	},
+	basePath + "/transactions/totals": {
+		"GET": {Handler: controllers.TransactionTotals, Roles: readers, Scopes: []string{models.ScopeTransactionsRead}},
+	},
+	basePath + "/transactions/export": {
+		"GET": {Handler: controllers.ExportTransactions, Roles: readers, Scopes: []string{models.ScopeTransactionsRead}},
 	},
 	"/public/v2/notification/subscription": {
-		"POST": controllers.ActivationDeactivationNotification,
+		"POST": {Handler: controllers.ActivationDeactivationNotification},
 	},
 	"/public/v2/notification/charge": {
-		"POST": controllers.ChargeNotification,
+		"POST": {Handler: controllers.ChargeNotification},
+	},
+	basePath + "/notification/rejections": {
+		"GET": {Handler: controllers.GetNotificationRejections, Roles: adminOnly, Scopes: []string{models.ScopePartnersAdmin}},
 	},
 	//NOTE: Old versions migrated from previous version
 	"/api/v1/he/activation": {
-		"POST": controllers.ActivateSubscriber,
+		"POST": {Handler: controllers.ActivateSubscriber, Roles: partnerOnly, Scopes: []string{models.ScopeSubscriptionsWrite}, RateLimited: true},
 	},
 	"/api/v1/he/deactivation": {
-		"POST": controllers.DeActivateSubscriber,
+		"POST": {Handler: controllers.DeActivateSubscriber, Roles: partnerOnly, Scopes: []string{models.ScopeSubscriptionsWrite}, RateLimited: true},
 	},
 	"/api/v1/he/charge": {
-		"POST": controllers.ChargeSubscriber,
+		"POST": {Handler: controllers.ChargeSubscriber, Roles: partnerOnly, Scopes: []string{models.ScopeChargeWrite}, RateLimited: true},
 	},
 	"/public/token/:service": {
-		"POST": controllers.MigratedToken,
+		"POST": {Handler: controllers.MigratedToken},
 	},
-}
\ No newline at end of file
+}
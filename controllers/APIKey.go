@@ -0,0 +1,57 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"github.com/apeli23/infinity/models"
+	"github.com/apeli23/infinity/services"
+)
+
+func CreateAPIKey(ctx *gin.Context) {
+	request := models.APIKeyRequest{}
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	key, secret, err := services.CreateAPIKey(ctx.GetString("user_id"), ctx.GetString("account_id"), ctx.GetString("scope"), request)
+	if err != nil {
+		logrus.Error(err)
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// the key is only ever returned here, we only keep its hash
+	ctx.AbortWithStatusJSON(http.StatusCreated, gin.H{
+		"api_key": key,
+		"key":     secret,
+	})
+}
+
+func ListAPIKeys(ctx *gin.Context) {
+	keys, err := services.ListAPIKeys(ctx.GetString("user_id"))
+	if err != nil {
+		logrus.Error(err)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch api keys"})
+		return
+	}
+	ctx.JSON(http.StatusOK, keys)
+}
+
+func RevokeAPIKey(ctx *gin.Context) {
+	err := services.RevokeAPIKey(ctx.GetString("user_id"), ctx.Param("id"))
+	if errors.Is(err, services.ErrAPIKeyNotFound) {
+		ctx.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		logrus.Error(err)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke api key"})
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
@@ -0,0 +1,62 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"github.com/apeli23/infinity/models"
+	"github.com/apeli23/infinity/services"
+)
+
+// loginAllowed answers 429 with Retry-After when email or the caller's IP address have to wait before trying again,
+// otherwise it counts the attempt. Login handlers call it before checking the password so throttled attempts cost
+// no bcrypt round and concurrent attempts cannot all get past the throttle.
+func loginAllowed(ctx *gin.Context, email string) bool {
+	retryAfter, err := services.CheckLogin(email, ctx.ClientIP())
+	if errors.Is(err, services.ErrLoginThrottled) {
+		ctx.Header("Retry-After", fmt.Sprintf("%d", int64(math.Ceil(retryAfter.Seconds()))))
+		ctx.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		return false
+	}
+	if err != nil {
+		logrus.Error(err)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "couldn't check login throttle"})
+		return false
+	}
+	return true
+}
+
+func loginFailed(ctx *gin.Context, email, reason string) {
+	logrus.Warnf("LOGIN FAILED | EMAIL : %s | IP : %s | ENDPOINT : %s | REASON : %s", email, ctx.ClientIP(), ctx.FullPath(), reason)
+	if err := services.RecordLoginFailure(email, ctx.ClientIP(), ctx.FullPath(), reason); err != nil {
+		logrus.Error(err)
+	}
+}
+
+func loginSucceeded(ctx *gin.Context, email string) {
+	if err := services.RecordLoginSuccess(email, ctx.ClientIP()); err != nil {
+		logrus.Error(err)
+	}
+}
+
+// UnlockLogin lets an admin lift the lockout of an email or IP address before it expires.
+func UnlockLogin(ctx *gin.Context) {
+	unlock := models.LoginUnlock{}
+	if err := ctx.ShouldBindJSON(&unlock); err != nil || (unlock.Email == "" && unlock.IP == "") {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "email or ip is required"})
+		return
+	}
+
+	cleared, err := services.UnlockLogin(unlock)
+	if err != nil {
+		logrus.Error(err)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to unlock login"})
+		return
+	}
+	ctx.AbortWithStatusJSON(http.StatusOK, gin.H{"cleared": cleared})
+}
@@ -1,6 +1,7 @@
 package controllers
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 
@@ -9,6 +10,7 @@ import (
 	"github.com/apeli23/infinity/services"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
 )
 
 func MigratedToken(ctx *gin.Context) {
@@ -24,19 +26,26 @@ func MigratedToken(ctx *gin.Context) {
 		return
 	}
 
+	if !loginAllowed(ctx, loginInstance.Username) {
+		return
+	}
 	partner, err := services.GetPartnerByEmail(loginInstance.Username)
 	if err != nil {
 		logrus.Error(err)
-		ctx.Status(http.StatusBadRequest)
+		// unknown partners are refused like wrong secrets, so the response does not tell which partners exist
+		services.CheckUnknownAccount(loginInstance.Password)
+		loginFailed(ctx, loginInstance.Username, "unknown partner")
+		ctx.Status(http.StatusUnauthorized)
 		return
 	}
 	if !services.CheckPasswordHash(loginInstance.Password, partner.Secret) {
-		logrus.Error("invalid login credentials")
+		loginFailed(ctx, loginInstance.Username, "invalid secret")
 		ctx.Status(http.StatusUnauthorized)
 		return
 	}
+	loginSucceeded(ctx, loginInstance.Username)
 
-	token, err := services.GenerateToken(fmt.Sprintf("%d", partner.ID), "header enrichment")
+	token, err := services.GenerateToken(fmt.Sprintf("%d", partner.ID), "", models.RolePartner, services.RoleScopes(models.RolePartner))
 	if err != nil {
 		logrus.Error(err)
 		ctx.Status(http.StatusInternalServerError)
@@ -60,13 +69,19 @@ func CreatePartner(ctx *gin.Context) {
 		return
 	}
 
-	if err := services.CreatePartner(&partner); err != nil {
+	secret, webhookSecret, err := services.CreatePartner(&partner)
+	if err != nil {
 		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
 			"error": "invalid request",
 		})
 		return
 	}
-	ctx.AbortWithStatusJSON(http.StatusCreated, &partner)
+	// the secret is only ever returned here, we only keep its hash
+	ctx.AbortWithStatusJSON(http.StatusCreated, gin.H{
+		"partner":        &partner,
+		"secret":         secret,
+		"webhook_secret": webhookSecret,
+	})
 
 }
 
@@ -82,28 +97,39 @@ func GetPartnerToken(ctx *gin.Context) {
 		return
 	}
 
+	if !loginAllowed(ctx, login.Username) {
+		return
+	}
 	partner, err := services.GetPartnerByEmail(login.Username)
 	if err != nil {
 		logrus.Error(err)
-		ctx.Status(http.StatusBadRequest)
+		// unknown partners are refused like wrong secrets, so the response does not tell which partners exist
+		services.CheckUnknownAccount(login.Password)
+		loginFailed(ctx, login.Username, "unknown partner")
+		ctx.Status(http.StatusUnauthorized)
 		return
 	}
 	if !services.CheckPasswordHash(login.Password, partner.Secret) {
-		logrus.Error("invalid login credentials")
+		loginFailed(ctx, login.Username, "invalid secret")
 		ctx.Status(http.StatusUnauthorized)
 		return
 	}
+	loginSucceeded(ctx, login.Username)
+
+	scope, err := services.GrantScopes(models.RolePartner, login.Scope)
+	if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	token, err := services.GenerateToken(fmt.Sprintf("%d", partner.ID), "header enrichment")
+	pair, err := services.IssueTokenPair(fmt.Sprintf("%d", partner.ID), "", models.RolePartner, scope, "")
 	if err != nil {
 		logrus.Error(err)
 		ctx.Status(http.StatusInternalServerError)
 		return
 	}
 
-	ctx.AbortWithStatusJSON(http.StatusOK, gin.H{
-		"token": token,
-	})
+	ctx.AbortWithStatusJSON(http.StatusOK, pair)
 }
 
 func GetAllPartners(ctx *gin.Context) {
@@ -114,4 +140,68 @@ func GetAllPartners(ctx *gin.Context) {
 	}
 
 	ctx.JSON(http.StatusOK, partners)
-}
\ No newline at end of file
+}
+func RotateWebhookSecret(ctx *gin.Context) {
+	partnerId := ctx.GetString("user_id")
+
+	secret, previousExpiresAt, err := services.RotateWebhookSecret(partnerId)
+	if err != nil {
+		logrus.Error(err)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to rotate webhook secret"})
+		return
+	}
+
+	ctx.AbortWithStatusJSON(http.StatusOK, gin.H{
+		"webhook_secret":             secret,
+		"previous_secret_expires_at": previousExpiresAt,
+	})
+}
+
+// RotatePartnerSecret replaces the caller's login secret. The old secret and the refresh tokens issued with it stop working.
+func RotatePartnerSecret(ctx *gin.Context) {
+	secret, err := services.RotatePartnerSecret(ctx.GetString("user_id"))
+	if err != nil {
+		logrus.Error(err)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to rotate secret"})
+		return
+	}
+	ctx.AbortWithStatusJSON(http.StatusOK, gin.H{"secret": secret})
+}
+
+// IssuePartnerResetToken lets an admin issue a reset token for a partner that lost its secret, to be passed on out of band.
+func IssuePartnerResetToken(ctx *gin.Context) {
+	token, expiresAt, err := services.IssuePartnerResetToken(ctx.Param("id"))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		ctx.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "partner not found"})
+		return
+	}
+	if err != nil {
+		logrus.Error(err)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to issue reset token"})
+		return
+	}
+	ctx.AbortWithStatusJSON(http.StatusCreated, gin.H{
+		"reset_token": token,
+		"expires_at":  expiresAt,
+	})
+}
+
+func ResetPartnerSecret(ctx *gin.Context) {
+	reset := models.SecretReset{}
+	if err := ctx.ShouldBindJSON(&reset); err != nil {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	secret, err := services.ResetPartnerSecret(reset.Token)
+	if errors.Is(err, services.ErrInvalidResetToken) {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		logrus.Error(err)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to reset secret"})
+		return
+	}
+	ctx.AbortWithStatusJSON(http.StatusOK, gin.H{"secret": secret})
+}
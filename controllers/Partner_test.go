@@ -0,0 +1,41 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestResetPartnerSecretRejected(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name string
+		body string
+	}{
+		{name: "empty body", body: ``},
+		{name: "no token", body: `{}`},
+		{name: "empty token", body: `{"token": ""}`},
+		{name: "token is not a string", body: `{"token": 42}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			ctx, _ := gin.CreateTestContext(recorder)
+			ctx.Request = httptest.NewRequest(http.MethodPost, "/public/v2/partner/secret/reset", strings.NewReader(test.body))
+			ctx.Request.Header.Set("Content-Type", "application/json")
+
+			ResetPartnerSecret(ctx)
+
+			if recorder.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want %d", recorder.Code, http.StatusBadRequest)
+			}
+			if strings.Contains(recorder.Body.String(), "secret\":") {
+				t.Errorf("a secret was returned: %s", recorder.Body.String())
+			}
+		})
+	}
+}
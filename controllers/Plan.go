@@ -0,0 +1,98 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+
+	"github.com/apeli23/infinity/models"
+	"github.com/apeli23/infinity/services"
+)
+
+// isAdmin reports whether the caller's token carries the admin role.
+func isAdmin(ctx *gin.Context) bool {
+	return ctx.GetString("role") == models.RoleAdmin
+}
+
+// planError maps plan service errors onto HTTP responses.
+func planError(ctx *gin.Context, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		ctx.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "plan not found"})
+	case errors.Is(err, services.ErrForbidden):
+		ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrUnknownOperator), errors.Is(err, services.ErrNoPartner):
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrPlanArchived), errors.Is(err, services.ErrPlanExists):
+		ctx.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		logrus.Error(err)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to save plan"})
+	}
+}
+
+func ListPlans(ctx *gin.Context) {
+	partnerId := ctx.GetString("user_id")
+	// admins may look at another partner's plans
+	if requested := ctx.Query("partner"); requested != "" && requested != partnerId {
+		if !isAdmin(ctx) {
+			ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": services.ErrForbidden.Error()})
+			return
+		}
+		partnerId = requested
+	}
+
+	plans, err := services.ListPlans(partnerId, ctx.Query("include_archived") == "true")
+	if err != nil {
+		logrus.Error(err)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch plans"})
+		return
+	}
+	ctx.JSON(http.StatusOK, plans)
+}
+
+func CreatePlan(ctx *gin.Context) {
+	request := models.PlanRequest{}
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := request.Validate(); err != nil {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	plan, err := services.CreatePlan(ctx.GetString("user_id"), isAdmin(ctx), request)
+	if err != nil {
+		planError(ctx, err)
+		return
+	}
+	ctx.AbortWithStatusJSON(http.StatusCreated, plan)
+}
+
+func UpdatePlan(ctx *gin.Context) {
+	update := models.PlanUpdate{}
+	if err := ctx.ShouldBindJSON(&update); err != nil {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	plan, err := services.UpdatePlan(ctx.GetString("user_id"), isAdmin(ctx), ctx.Param("id"), update)
+	if err != nil {
+		planError(ctx, err)
+		return
+	}
+	ctx.AbortWithStatusJSON(http.StatusOK, plan)
+}
+
+func ArchivePlan(ctx *gin.Context) {
+	plan, err := services.ArchivePlan(ctx.GetString("user_id"), isAdmin(ctx), ctx.Param("id"))
+	if err != nil {
+		planError(ctx, err)
+		return
+	}
+	ctx.AbortWithStatusJSON(http.StatusOK, plan)
+}
@@ -0,0 +1,38 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"github.com/apeli23/infinity/models"
+	"github.com/apeli23/infinity/services"
+)
+
+func ListRateLimits(ctx *gin.Context) {
+	limits, err := services.ListRateLimits(ctx.Param("id"))
+	if err != nil {
+		logrus.Error(err)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch rate limits"})
+		return
+	}
+	ctx.JSON(http.StatusOK, limits)
+}
+
+// SetRateLimit lets an admin set a partner's rate and daily quota on one route, or on every route with "*".
+func SetRateLimit(ctx *gin.Context) {
+	request := models.RateLimitRequest{}
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	limit, err := services.SetRateLimit(ctx.Param("id"), request)
+	if err != nil {
+		logrus.Error(err)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to set rate limit"})
+		return
+	}
+	ctx.AbortWithStatusJSON(http.StatusOK, limit)
+}
@@ -1,68 +1,116 @@
 package controllers
 
 import (
-	"encoding/json"
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
-	"github.com/apeli23/infinity/database"
+	"gorm.io/gorm"
 	"github.com/apeli23/infinity/models"
 	"github.com/apeli23/infinity/services"
-	"github.com/apeli23/infinity/utils"
 )
 
 func ActivationDeactivationNotification(ctx *gin.Context) {
 
 	notification := models.Callback{}
 	if err := ctx.ShouldBindJSON(&notification); err != nil {
-		logrus.Error(err)
+		// answered 400 like invalid data items, an empty 200 would tell the SDP the notification was applied
+		notificationDataRejected(ctx, err)
+		return
+	}
+	if err := services.NormalizeCallbackMsisdn(&notification); err != nil {
+		notificationDataRejected(ctx, err)
+		return
+	}
+	data, err := models.DecodeSubscriptionNotification(notification.RequestParam)
+	if err != nil {
+		notificationDataRejected(ctx, err)
 		return
 	}
-	services.ActDeactProcess(notification)
+	if err := services.ActDeactProcess(notification, data); err != nil {
+		notificationFailed(ctx, err)
+	}
 
 }
 
 func ChargeNotification(ctx *gin.Context) {
+
 	notification := models.Callback{}
-	transaction := models.Transaction{}
-	offercode := ""
+	if err := ctx.ShouldBindJSON(&notification); err != nil {
+		// answered 400 like invalid data items, an empty 200 would tell the SDP the notification was applied
+		notificationDataRejected(ctx, err)
+		return
+	}
+	if err := services.NormalizeCallbackMsisdn(&notification); err != nil {
+		notificationDataRejected(ctx, err)
+		return
+	}
+	data, err := models.DecodeChargeNotification(notification.RequestParam)
+	if err != nil {
+		notificationDataRejected(ctx, err)
+		return
+	}
+	if err := services.ChargeProcess(notification, data); err != nil {
+		notificationFailed(ctx, err)
+	}
 
-	for _, data := range notification.RequestParam.Data {
+}
 
-		switch data.Name {
-		case "ClientTransactionId":
-			transaction.ExternalID = data.Value.(string)
-		case "OfferCode":
-			offercode = data.Value.(string)
-		case "Reason":
-			transaction.Status = data.Value.(string)
-		}
+// notificationFailed answers a notification that could not be applied. Replays are rejected like NotificationAuth
+// does, other failures answer 500 so the SDP delivers the notification again.
+func notificationFailed(ctx *gin.Context, err error) {
+	if errors.Is(err, services.ErrNotificationReplay) {
+		count := services.CountNotificationRejection(err)
+		logrus.Warnf("NOTIFICATION REJECTED | PATH : %s | IP : %s | REASON : %v | COUNT : %d", ctx.FullPath(), ctx.ClientIP(), err, count)
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	logrus.Error(err)
+	ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to process notification"})
+}
 
+// notificationDataRejected answers the SDP that the data items of its notification are invalid.
+func notificationDataRejected(ctx *gin.Context, err error) {
+	services.CountNotificationRejection(services.ErrNotificationData)
+	logrus.Warnf("SDP NOTIFICATION REJECTED | %v", err)
+	var invalid *models.NotificationError
+	if errors.As(err, &invalid) {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": services.ErrNotificationData.Error(), "problems": invalid.Problems})
+		return
 	}
+	ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": services.ErrNotificationData.Error(), "problems": []string{err.Error()}})
+}
 
-	if transaction.Status == "Successful" {
-		transaction.StatusDescription = "Subscriber charged"
-	} else {
-		transaction.StatusDescription = transaction.Status
+// normalizeMsisdn puts the MSISDN in E.164 digits, answering 400 when it is not a valid subscriber number.
+func normalizeMsisdn(ctx *gin.Context, number *string) bool {
+	normalized, err := services.NormalizeMsisdn(*number)
+	if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
 	}
+	*number = normalized
+	return true
+}
 
-	// NOTE: Better to use partner_id and external_id.
-	// But plan_id is okay given that it is a 1 to 1 representation of customer.
-	if err := database.Db.Debug().Table("transactions_v").Where("external_id = ? AND plan_id = ?", transaction.ExternalID, offercode).First(&transaction).Error; err != nil {
-		logrus.Error(err)
+// idempotentResponse sends the SDP request through services.Idempotent and writes the outcome,
+// replaying the stored response when the partner retries a requestId.
+func idempotentResponse(ctx *gin.Context, partnerId, operation string, request *models.HeRequest, call func() (models.HeResponse, error)) {
+	response, replayed, err := services.Idempotent(partnerId, operation, request, call)
+	switch {
+	case errors.Is(err, services.ErrIdempotencyConflict), errors.Is(err, services.ErrIdempotencyInProgress),
+		errors.Is(err, services.ErrIdempotencyUnknown):
+		ctx.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": err.Error()})
 		return
-	}
-	err := database.Db.Debug().Table("transactions").Where("id = ?", transaction.ExternalID, transaction.ID).Updates(&transaction).Error
-	if err != nil {
-		logrus.Error(err)
+	case err != nil:
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	payload, _ := json.Marshal(notification)
-	utils.Request(string(payload), map[string][]string{
-		"Content-Type": {"application/json"},
-	}, transaction.Callback, "POST")
 
+	if replayed {
+		ctx.Header("Idempotent-Replayed", "true")
+	}
+	ctx.AbortWithStatusJSON(http.StatusAccepted, response)
 }
 
 func ActivateSubscriber(ctx *gin.Context) {
@@ -74,6 +122,9 @@ func ActivateSubscriber(ctx *gin.Context) {
 		ctx.Status(http.StatusBadRequest)
 		return
 	}
+	if !normalizeMsisdn(ctx, &activation.Msisdn) {
+		return
+	}
 
 	plan := services.PartnerCheckPlanAccess(partnerId, activation.OfferCode)
 	if plan.ID == "" {
@@ -81,12 +132,9 @@ func ActivateSubscriber(ctx *gin.Context) {
 		return
 	}
 
-	response, err := services.SendActivation(&activation, "USSD")
-	if err != nil {
-		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-	ctx.AbortWithStatusJSON(http.StatusAccepted, response)
+	idempotentResponse(ctx, partnerId, "activation", &activation, func() (models.HeResponse, error) {
+		return services.SendActivation(&activation, "USSD")
+	})
 
 }
 
@@ -99,6 +147,9 @@ func WebActivateSubscriber(ctx *gin.Context) {
 		ctx.Status(http.StatusBadRequest)
 		return
 	}
+	if !normalizeMsisdn(ctx, &activation.Msisdn) {
+		return
+	}
 
 	plan := services.PartnerCheckPlanAccess(partnerId, activation.OfferCode)
 	if plan.ID == "" {
@@ -106,12 +157,9 @@ func WebActivateSubscriber(ctx *gin.Context) {
 		return
 	}
 
-	response, err := services.WebActivation(&activation, "WEB")
-	if err != nil {
-		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-	ctx.AbortWithStatusJSON(http.StatusAccepted, response)
+	idempotentResponse(ctx, partnerId, "web_activation", &activation, func() (models.HeResponse, error) {
+		return services.WebActivation(&activation, "WEB")
+	})
 
 }
 
@@ -124,6 +172,9 @@ func DeActivateSubscriber(ctx *gin.Context) {
 		ctx.Status(http.StatusBadRequest)
 		return
 	}
+	if !normalizeMsisdn(ctx, &deactivation.Msisdn) {
+		return
+	}
 
 	plan := services.PartnerCheckPlanAccess(partnerId, deactivation.OfferCode)
 	if plan.ID == "" {
@@ -131,12 +182,9 @@ func DeActivateSubscriber(ctx *gin.Context) {
 		return
 	}
 
-	response, err := services.SendDeActivation(&deactivation, "USSD")
-	if err != nil {
-		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-	ctx.AbortWithStatusJSON(http.StatusAccepted, response)
+	idempotentResponse(ctx, partnerId, "deactivation", &deactivation, func() (models.HeResponse, error) {
+		return services.SendDeActivation(&deactivation, "USSD")
+	})
 
 }
 
@@ -149,6 +197,9 @@ func ChargeSubscriber(ctx *gin.Context) {
 		ctx.Status(http.StatusBadRequest)
 		return
 	}
+	if !normalizeMsisdn(ctx, &charging.Msisdn) {
+		return
+	}
 
 	plan := services.PartnerCheckPlanAccess(partnerId, charging.OfferCode)
 	if plan.ID == "" {
@@ -156,12 +207,54 @@ func ChargeSubscriber(ctx *gin.Context) {
 		return
 	}
 
-	response, err := services.SendCharging(&charging)
+	idempotentResponse(ctx, partnerId, "charge", &charging, func() (models.HeResponse, error) {
+		return services.SendCharging(&charging)
+	})
+
+}
+func GetNotificationRejections(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, services.NotificationRejections())
+}
 
+func ListSubscriptions(ctx *gin.Context) {
+	partnerId := ctx.GetString("user_id")
+	filter := models.SubscriptionFilter{}
+
+	if err := ctx.ShouldBindQuery(&filter); err != nil {
+		logrus.Error(err)
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid filters"})
+		return
+	}
+	if filter.Msisdn != "" && !normalizeMsisdn(ctx, &filter.Msisdn) {
+		return
+	}
+
+	subscriptions, nextCursor, err := services.ListPartnerSubscriptions(partnerId, filter)
 	if err != nil {
-		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		logrus.Error(err)
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "failed to fetch subscriptions"})
 		return
 	}
-	ctx.AbortWithStatusJSON(http.StatusAccepted, response)
 
-}
\ No newline at end of file
+	ctx.JSON(http.StatusOK, gin.H{
+		"data":        subscriptions,
+		"next_cursor": nextCursor,
+	})
+}
+
+func GetSubscription(ctx *gin.Context) {
+	partnerId := ctx.GetString("user_id")
+
+	details, err := services.GetPartnerSubscription(partnerId, ctx.Param("external_id"))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		ctx.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "subscription not found"})
+		return
+	}
+	if err != nil {
+		logrus.Error(err)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch subscription"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, details)
+}
@@ -0,0 +1,59 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestNotificationRejectedBeforeProcessing(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handlers := map[string]gin.HandlerFunc{
+		"activation": ActivationDeactivationNotification,
+		"charge":     ChargeNotification,
+	}
+	tests := []struct {
+		name    string
+		body    string
+		problem string
+	}{
+		{name: "empty body", body: ``, problem: "EOF"},
+		{name: "malformed JSON", body: `{"requestId": "n1", "requestParam": {"data": [`, problem: "unexpected EOF"},
+		{name: "not an object", body: `["n1"]`, problem: "cannot unmarshal"},
+		{name: "missing requestId", body: `{"requestParam": {"data": []}}`, problem: "RequestId"},
+		{name: "data is not a list", body: `{"requestId": "n1", "requestParam": {"data": {"name": "Msisdn"}}}`, problem: "cannot unmarshal"},
+		{name: "missing data items", body: `{"requestId": "n1", "requestParam": {"data": [{"name": "Msisdn", "value": "254712345678"}]}}`, problem: "ClientTransactionId is missing"},
+		{name: "invalid msisdn", body: `{"requestId": "n1", "requestParam": {"data": [{"name": "Msisdn", "value": "07123"}]}}`, problem: "msisdn"},
+	}
+
+	for kind, handler := range handlers {
+		for _, test := range tests {
+			t.Run(kind+"/"+test.name, func(t *testing.T) {
+				recorder := httptest.NewRecorder()
+				ctx, _ := gin.CreateTestContext(recorder)
+				ctx.Request = httptest.NewRequest(http.MethodPost, "/public/v2/notification/"+kind, strings.NewReader(test.body))
+				ctx.Request.Header.Set("Content-Type", "application/json")
+
+				handler(ctx)
+
+				if recorder.Code != http.StatusBadRequest {
+					t.Fatalf("status = %d, want %d", recorder.Code, http.StatusBadRequest)
+				}
+				answer := struct {
+					Error    string   `json:"error"`
+					Problems []string `json:"problems"`
+				}{}
+				if err := json.Unmarshal(recorder.Body.Bytes(), &answer); err != nil {
+					t.Fatalf("body %q: %v", recorder.Body.String(), err)
+				}
+				if !strings.Contains(strings.Join(answer.Problems, "; "), test.problem) {
+					t.Errorf("problems = %q, want one about %q", answer.Problems, test.problem)
+				}
+			})
+		}
+	}
+}
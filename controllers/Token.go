@@ -0,0 +1,64 @@
+package controllers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"github.com/apeli23/infinity/models"
+	"github.com/apeli23/infinity/services"
+)
+
+func RefreshToken(ctx *gin.Context) {
+	request := models.RefreshRequest{}
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	pair, err := services.RefreshTokenPair(request.RefreshToken)
+	if err != nil {
+		logrus.Error(err)
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": services.ErrInvalidRefreshToken.Error()})
+		return
+	}
+	ctx.AbortWithStatusJSON(http.StatusOK, pair)
+}
+
+// RevokeToken logs the caller out: the access token used for this request is revoked, and so is the refresh token when given.
+func RevokeToken(ctx *gin.Context) {
+	request := models.RevokeRequest{}
+	if err := ctx.ShouldBindJSON(&request); err != nil && ctx.Request.ContentLength > 0 {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	expiresAt, ok := ctx.Get("token_expires_at")
+	if !ok {
+		// API keys have no session to end, they are revoked through the api keys endpoint
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "api keys cannot be revoked here"})
+		return
+	}
+	if err := services.RevokeAccessToken(ctx.GetString("jti"), expiresAt.(time.Time)); err != nil {
+		logrus.Error(err)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke token"})
+		return
+	}
+
+	if request.RefreshToken != "" {
+		if err := services.RevokeRefreshToken(request.RefreshToken, ctx.GetString("user_id"), ctx.GetString("account_id")); err != nil {
+			logrus.Error(err)
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke refresh token"})
+			return
+		}
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+// GetJWKS publishes the public keys access tokens are signed with so other services can verify them.
+func GetJWKS(ctx *gin.Context) {
+	ctx.Header("Cache-Control", "public, max-age=300")
+	ctx.JSON(http.StatusOK, services.JWKS())
+}
@@ -0,0 +1,140 @@
+package controllers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"github.com/apeli23/infinity/models"
+	"github.com/apeli23/infinity/services"
+)
+
+func ListTransactions(ctx *gin.Context) {
+	partnerId := ctx.GetString("user_id")
+	filter := models.TransactionFilter{}
+
+	if err := ctx.ShouldBindQuery(&filter); err != nil {
+		logrus.Error(err)
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid filters"})
+		return
+	}
+	if filter.Msisdn != "" && !normalizeMsisdn(ctx, &filter.Msisdn) {
+		return
+	}
+
+	transactions, nextCursor, err := services.ListPartnerTransactions(partnerId, filter)
+	if err != nil {
+		logrus.Error(err)
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "failed to fetch transactions"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"data":        transactions,
+		"next_cursor": nextCursor,
+	})
+}
+
+func TransactionTotals(ctx *gin.Context) {
+	partnerId := ctx.GetString("user_id")
+	filter := models.TransactionFilter{}
+
+	if err := ctx.ShouldBindQuery(&filter); err != nil {
+		logrus.Error(err)
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid filters"})
+		return
+	}
+	if filter.Msisdn != "" && !normalizeMsisdn(ctx, &filter.Msisdn) {
+		return
+	}
+
+	totals, err := services.PartnerTransactionTotals(partnerId, filter)
+	if err != nil {
+		logrus.Error(err)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to compute totals"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"totals": totals})
+}
+
+// ExportTransactions streams the partner's transactions as CSV (default) or JSON lines (`format=jsonl`) for reconciliation.
+func ExportTransactions(ctx *gin.Context) {
+	partnerId := ctx.GetString("user_id")
+	filter := models.TransactionFilter{}
+
+	if err := ctx.ShouldBindQuery(&filter); err != nil {
+		logrus.Error(err)
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid filters"})
+		return
+	}
+	if filter.Msisdn != "" && !normalizeMsisdn(ctx, &filter.Msisdn) {
+		return
+	}
+
+	filename := fmt.Sprintf("transactions-%s", time.Now().Format("20060102150405"))
+	var write func(models.TransactionRecord) error
+	var flush func()
+
+	switch ctx.DefaultQuery("format", "csv") {
+	case "csv":
+		ctx.Header("Content-Type", "text/csv")
+		ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s.csv", filename))
+		writer := csv.NewWriter(ctx.Writer)
+		if err := writer.Write([]string{"id", "external_id", "plan", "msisdn", "amount", "status", "description", "created_at", "updated_at"}); err != nil {
+			logrus.Error(err)
+			return
+		}
+		write = func(record models.TransactionRecord) error {
+			return writer.Write([]string{
+				strconv.FormatUint(uint64(record.ID), 10),
+				record.ExternalID,
+				record.PlanID,
+				record.MSISDN,
+				record.Amount,
+				record.Status,
+				record.StatusDescription,
+				record.CreatedAt.Format(time.RFC3339),
+				record.UpdatedAt.Format(time.RFC3339),
+			})
+		}
+		flush = writer.Flush
+	case "jsonl":
+		ctx.Header("Content-Type", "application/x-ndjson")
+		ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s.jsonl", filename))
+		encoder := json.NewEncoder(ctx.Writer)
+		write = func(record models.TransactionRecord) error {
+			return encoder.Encode(record)
+		}
+		flush = func() {}
+	default:
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "format must be csv or jsonl"})
+		return
+	}
+
+	ctx.Status(http.StatusOK)
+	count := 0
+	err := services.ExportPartnerTransactions(partnerId, filter, func(record models.TransactionRecord) error {
+		if err := write(record); err != nil {
+			return err
+		}
+		// push rows out regularly so large exports start downloading straight away
+		if count++; count%500 == 0 {
+			flush()
+			ctx.Writer.Flush()
+		}
+		return nil
+	})
+	flush()
+	ctx.Writer.Flush()
+	if err != nil {
+		// headers are already sent, all we can do is log and cut the stream short
+		logrus.Error(err)
+	}
+}
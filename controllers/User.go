@@ -0,0 +1,132 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"github.com/apeli23/infinity/models"
+	"github.com/apeli23/infinity/services"
+)
+
+func GetUserToken(ctx *gin.Context) {
+
+	login := models.UserLogin{}
+
+	if err := ctx.ShouldBindJSON(&login); err != nil {
+		logrus.Error(err)
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request",
+		})
+		return
+	}
+
+	if !loginAllowed(ctx, login.Username) {
+		return
+	}
+	user, membership, err := services.UserLogin(login)
+	if err != nil {
+		logrus.Error(err)
+		loginFailed(ctx, login.Username, err.Error())
+		ctx.Status(http.StatusUnauthorized)
+		return
+	}
+	loginSucceeded(ctx, login.Username)
+
+	role := membership.Role
+	if user.Role == models.RoleAdmin {
+		role = models.RoleAdmin
+	}
+	partnerId := ""
+	if membership.PartnerID != 0 {
+		partnerId = fmt.Sprintf("%d", membership.PartnerID)
+	}
+
+	scope, err := services.GrantScopes(role, login.Scope)
+	if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	pair, err := services.IssueTokenPair(partnerId, fmt.Sprintf("%d", user.ID), role, scope, "")
+	if err != nil {
+		logrus.Error(err)
+		ctx.Status(http.StatusInternalServerError)
+		return
+	}
+
+	ctx.AbortWithStatusJSON(http.StatusOK, pair)
+}
+
+func InviteUser(ctx *gin.Context) {
+	invite := models.UserInvite{}
+	if err := ctx.ShouldBindJSON(&invite); err != nil {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	member, inviteToken, err := services.InviteUser(ctx.GetString("user_id"), invite)
+	if errors.Is(err, services.ErrAlreadyMember) {
+		ctx.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		logrus.Error(err)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to invite user"})
+		return
+	}
+
+	// the invite token is only ever returned here, the partner passes it on to the user
+	ctx.AbortWithStatusJSON(http.StatusCreated, gin.H{
+		"member":       member,
+		"invite_token": inviteToken,
+	})
+}
+
+func AcceptInvite(ctx *gin.Context) {
+	acceptance := models.InviteAcceptance{}
+	if err := ctx.ShouldBindJSON(&acceptance); err != nil {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := services.AcceptInvite(acceptance); err != nil {
+		logrus.Error(err)
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": services.ErrInvalidInvite.Error()})
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+func ListPartnerUsers(ctx *gin.Context) {
+	members, err := services.ListPartnerMembers(ctx.GetString("user_id"))
+	if err != nil {
+		logrus.Error(err)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch users"})
+		return
+	}
+	ctx.JSON(http.StatusOK, members)
+}
+
+func DeactivatePartnerUser(ctx *gin.Context) {
+	userId := ctx.Param("id")
+	if userId == ctx.GetString("account_id") {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "you cannot deactivate yourself"})
+		return
+	}
+
+	err := services.DeactivateMember(ctx.GetString("user_id"), userId)
+	if errors.Is(err, services.ErrMemberNotFound) {
+		ctx.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		logrus.Error(err)
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to deactivate user"})
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
@@ -0,0 +1,49 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TestUserRequestsRejected covers the member management requests refused before the partner's users are touched.
+func TestUserRequestsRejected(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name    string
+		handler gin.HandlerFunc
+		body    string
+		param   string
+		want    int
+	}{
+		{name: "invite without email", handler: InviteUser, body: `{"role": "partner"}`, want: http.StatusBadRequest},
+		{name: "invite with invalid email", handler: InviteUser, body: `{"email": "ops", "role": "partner"}`, want: http.StatusBadRequest},
+		{name: "invite as admin", handler: InviteUser, body: `{"email": "ops@partner.com", "role": "admin"}`, want: http.StatusBadRequest},
+		{name: "accept without token", handler: AcceptInvite, body: `{"password": "correct horse"}`, want: http.StatusBadRequest},
+		{name: "accept with short password", handler: AcceptInvite, body: `{"token": "t", "password": "short"}`, want: http.StatusBadRequest},
+		{name: "deactivate yourself", handler: DeactivatePartnerUser, param: "12", want: http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			ctx, _ := gin.CreateTestContext(recorder)
+			ctx.Request = httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(test.body))
+			ctx.Request.Header.Set("Content-Type", "application/json")
+			ctx.Set("user_id", "7")
+			ctx.Set("account_id", "12")
+			if test.param != "" {
+				ctx.Params = gin.Params{{Key: "id", Value: test.param}}
+			}
+
+			test.handler(ctx)
+
+			if recorder.Code != test.want {
+				t.Errorf("status = %d, want %d: %s", recorder.Code, test.want, recorder.Body.String())
+			}
+		})
+	}
+}
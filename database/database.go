@@ -14,7 +14,9 @@ import (
 var Db *gorm.DB
 
 func init() {
-	database, err := gorm.Open(postgres.Open(os.Getenv("DATABASE_URL")), &gorm.Config{})
+	// the connection is only checked by Ping when the server starts, so that packages using Db can be imported,
+	// and tested, without a database
+	database, err := gorm.Open(postgres.Open(os.Getenv("DATABASE_URL")), &gorm.Config{DisableAutomaticPing: true})
 
 	if err != nil {
 		panic(err)
@@ -24,3 +26,12 @@ func init() {
 
 	logrus.Info("Completed migration")
 }
+
+// Ping checks that the database can be reached.
+func Ping() error {
+	db, err := Db.DB()
+	if err != nil {
+		return err
+	}
+	return db.Ping()
+}
@@ -1,6 +1,6 @@
 module github.com/apeli23/infinity
 
-go 1.18
+go 1.20
 
 require gorm.io/gorm v1.25.0
 
@@ -9,5 +9,11 @@ require (
 	github.com/golang-jwt/jwt/v4 v4.5.0
 	github.com/golang-migrate/migrate/v4 v4.15.2
 	github.com/sirupsen/logrus v1.9.0
+	golang.org/x/crypto v0.5.0
 	gorm.io/driver/postgres v1.0.8
 )
+
+require (
+	github.com/jinzhu/inflection v1.0.0 // indirect
+	github.com/jinzhu/now v1.1.5 // indirect
+)
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	"net/http"
 	"os"
 	// "path"
@@ -10,6 +14,11 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/sirupsen/logrus"
+
+	"github.com/apeli23/infinity/database"
+	"github.com/apeli23/infinity/models"
+	"github.com/apeli23/infinity/services"
 )
 
 //add CORS (Cross-Origin Resource Sharing) headers to HTTP responses
@@ -20,7 +29,7 @@ func CORSMiddleware() gin.HandlerFunc {
 		// The Access-Control-Allow-Credentials header is set to true, which allows the server to include cookies in the requests and responses.
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
 		//The Access-Control-Allow-Headers header lists the allowed request headers
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With, X-Api-Key")
 		// Access-Control-Allow-Methods header lists the allowed HTTP methods.
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
 
@@ -35,12 +44,28 @@ func CORSMiddleware() gin.HandlerFunc {
 // middleware function:  checks whether an incoming request has a valid JWT (JSON Web Token) in its Authorization header. 
 func ValidateToken() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		fmt.Println(ctx.FullPath())
 		//if the incoming request path starts with`/public`, skip the validation and pass request to the next handler
 		if strings.HasPrefix(ctx.FullPath(), "/public/") {
 			ctx.Next()
 			return
 		}
+		// partners may authenticate with an API key instead of a Bearer token
+		if apiKey := ctx.GetHeader("X-Api-Key"); apiKey != "" {
+			key, err := services.AuthenticateAPIKey(apiKey)
+			if err != nil {
+				ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+					"error": err.Error(),
+				})
+				return
+			}
+			ctx.Set("user_id", fmt.Sprintf("%d", key.PartnerID))
+			ctx.Set("account_id", "")
+			ctx.Set("role", models.RolePartner)
+			ctx.Set("scope", key.Scope)
+			ctx.Set("api_key_id", key.ID)
+			ctx.Next()
+			return
+		}
 		//if Authorization header exists, it is split into two parts (a prefix and the actual token) using the space character as a separator.
 		headerToken := ctx.GetHeader("Authorization")
 
@@ -57,11 +82,10 @@ func ValidateToken() gin.HandlerFunc {
 		//pass token using  jwt.ParseWithClaims() function from the "github.com/dgrijalva/jwt-go" library
 		token, err := jwt.ParseWithClaims(
 			signedToken[1],
-		//validate token  with the JWT registered claims and provide secret key
-			&jwt.RegisteredClaims{},
-			func(token *jwt.Token) (interface{}, error) {
-				return []byte(os.Getenv("AUTH_SECRET")), nil
-			},
+		//validate token  with our claims (registered claims plus partner, user and role) and provide secret key
+			&services.Claims{},
+			//the verification key is picked by the token's kid header
+			services.VerificationKey,
 		)
 		//throw status code 401 if theres an error
 		if err != nil {
@@ -72,8 +96,7 @@ func ValidateToken() gin.HandlerFunc {
 			return
 		}
 		//If the token is successfully parsed, the claims are extracted and added to the context using ctx.Set()
-		claims, ok := token.Claims.(*jwt.RegisteredClaims)
-		ctx.Set("user_id", claims.ID)
+		claims, ok := token.Claims.(*services.Claims)
 		if !ok {
 			// utils.Log.Error("couldn't parse claims")
 			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
@@ -88,40 +111,217 @@ func ValidateToken() gin.HandlerFunc {
 			})
 			return
 		}
+		// only accept tokens we issued for this API
+		if !claims.VerifyIssuer(services.TokenIssuer, true) || !claims.VerifyAudience(services.TokenAudience(), true) {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"error": "invalid token issuer or audience",
+			})
+			return
+		}
+		// tokens revoked on logout or after a leak are refused until they expire
+		revoked, err := services.IsTokenRevoked(claims.ID)
+		if err != nil {
+			logrus.Error(err)
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+				"error": "couldn't check token revocation",
+			})
+			return
+		}
+		if revoked {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"error": "Token revoked!",
+			})
+			return
+		}
+		// `user_id` is the partner the caller acts for, tokens issued before roles existed only carry it in the ID claim
+		partnerId := claims.PartnerID
+		if partnerId == "" && claims.UserID == "" {
+			partnerId = claims.ID
+		}
+		role := claims.Role
+		if role == "" {
+			role = models.RolePartner
+		}
+		// tokens issued before scopes existed hold every scope of their role
+		scope := claims.Scope
+		if scope == "" {
+			scope = services.RoleScopes(role)
+		}
+		// users lose access to a partner as soon as their membership is deactivated
+		if claims.UserID != "" && partnerId != "" {
+			active, err := services.MembershipActive(partnerId, claims.UserID)
+			if err != nil {
+				logrus.Error(err)
+				ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+					"error": "couldn't check partner membership",
+				})
+				return
+			}
+			if !active {
+				ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+					"error": "partner membership deactivated",
+				})
+				return
+			}
+		}
+		ctx.Set("user_id", partnerId)
+		ctx.Set("account_id", claims.UserID)
+		ctx.Set("role", role)
+		ctx.Set("scope", scope)
+		ctx.Set("jti", claims.ID)
+		ctx.Set("token_expires_at", claims.ExpiresAt.Time)
 		// /If the token is valid and has not expired, the function calls ctx.Next() to pass the request to the next handler in the chain.
 		ctx.Next()
 
 	}
 }
 
+// middleware function: only lets callers whose token role is one of roles through.
+func RequireRoles(roles ...string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		role := ctx.GetString("role")
+		for _, allowed := range roles {
+			if role == allowed {
+				ctx.Next()
+				return
+			}
+		}
+		ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"error": "insufficient role",
+		})
+	}
+}
+
+// middleware function: only lets callers whose token holds every scope in scopes through.
+func RequireScopes(scopes ...string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if !services.HasScopes(ctx.GetString("scope"), scopes...) {
+			ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error": fmt.Sprintf("token lacks required scopes: %s", strings.Join(scopes, " ")),
+			})
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// middleware function: applies the partner's rate limit and daily quota on the route, answering 429 with
+// Retry-After once either is used up. The X-RateLimit-* and X-Quota-* headers tell partners where they stand.
+func RateLimit() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		status, err := services.CheckRateLimit(ctx.GetString("user_id"), ctx.FullPath())
+		if err != nil && !errors.Is(err, services.ErrRateLimited) && !errors.Is(err, services.ErrQuotaExceeded) {
+			// a database hiccup should not take the SDP routes down with it
+			logrus.Error(err)
+			ctx.Next()
+			return
+		}
+
+		ctx.Header("X-RateLimit-Limit", fmt.Sprintf("%d", status.Limit))
+		ctx.Header("X-RateLimit-Remaining", fmt.Sprintf("%d", status.Remaining))
+		if status.QuotaLimit > 0 {
+			ctx.Header("X-Quota-Limit", fmt.Sprintf("%d", status.QuotaLimit))
+			ctx.Header("X-Quota-Remaining", fmt.Sprintf("%d", status.QuotaRemaining))
+		}
+		if err != nil {
+			ctx.Header("Retry-After", fmt.Sprintf("%d", int64(math.Ceil(status.RetryAfter.Seconds()))))
+			ctx.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// middleware function: authenticates the SDP notifications posted to `/public/v2/notification/*`, which skip ValidateToken.
+// The checks themselves are configured through the environment, see services.VerifyNotification.
+func NotificationAuth() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if !strings.HasPrefix(ctx.FullPath(), "/public/v2/notification/") {
+			ctx.Next()
+			return
+		}
+
+		body, err := io.ReadAll(ctx.Request.Body)
+		if err == nil {
+			//put the body back so the handler can still bind it
+			ctx.Request.Body = io.NopCloser(bytes.NewReader(body))
+			err = services.VerifyNotification(ctx.ClientIP(), ctx.Request.Header, body)
+		} else {
+			err = services.ErrNotificationBody
+		}
+
+		if err != nil {
+			count := services.CountNotificationRejection(err)
+			logrus.Warnf("NOTIFICATION REJECTED | PATH : %s | IP : %s | REASON : %v | COUNT : %d", ctx.FullPath(), ctx.ClientIP(), err, count)
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		ctx.Next()
+	}
+}
+
 // set up the main router for the Gin web framework. 
 func SetupRouter() *gin.Engine {
+	if err := database.Ping(); err != nil {
+		panic(err)
+	}
+	// start the background workers here rather than from package init, so importers of services do not run them
+	services.StartCallbackDispatcher()
+	services.StartSigningKeyRotation()
+	services.StartMsisdnNormalization()
+	services.StartRateLimiter()
+
 	//gin initiallization and middleware configuration
 	r:= gin.Default()
+	// only trust X-Forwarded-For from our own proxies, the notification allow-list depends on the client IP
+	if proxies := os.Getenv("TRUSTED_PROXIES"); proxies != "" {
+		r.SetTrustedProxies(strings.Split(proxies, ","))
+	} else {
+		r.SetTrustedProxies(nil)
+	}
 	// set up Cross-Origin Resource Sharing (CORS)
 	r.Use(CORSMiddleware())
 	// /validate the JWT token for authenticated routes
 	r.Use(ValidateToken())
+	// authenticate inbound SDP notifications
+	r.Use(NotificationAuth())
 	r.Use(gin.Recovery())
 
 	// loop over `Routes` map to deetermine HTTP metthod used andd add route to router with corresponding method and handler function
 	for path, handlers := range Routes {
-		for method, handler := range handlers {
+		for method, route := range handlers {
+			// routes listing roles only accept callers holding one of them, and all of the listed scopes
+			chain := []gin.HandlerFunc{}
+			if len(route.Roles) > 0 {
+				chain = append(chain, RequireRoles(route.Roles...))
+			}
+			if len(route.Scopes) > 0 {
+				chain = append(chain, RequireScopes(route.Scopes...))
+			}
+			if route.RateLimited {
+				chain = append(chain, RateLimit())
+			}
+			chain = append(chain, route.Handler)
+
 			switch method {
 			case "GET":
-				r.GET(path, handler)
+				r.GET(path, chain...)
 
 			case "POST":
-				r.POST(path, handler)
+				r.POST(path, chain...)
 
 			case "PUT":
-				r.PUT(path, handler)
+				r.PUT(path, chain...)
 
 			case "PATCH":
-				r.PATCH(path, handler)
+				r.PATCH(path, chain...)
 
 			case "DELETE":
-				r.DELETE(path, handler)
+				r.DELETE(path, chain...)
 			}
 		}
 	}
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS callback_attempts;
+DROP TABLE IF EXISTS callback_outbox;
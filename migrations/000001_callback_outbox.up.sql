@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS callback_outbox (
+    id              SERIAL PRIMARY KEY,
+    url             TEXT        NOT NULL,
+    payload         TEXT        NOT NULL,
+    status          VARCHAR(20) NOT NULL DEFAULT 'pending',
+    attempts        INT         NOT NULL DEFAULT 0,
+    max_attempts    INT         NOT NULL DEFAULT 10,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_error      TEXT        NOT NULL DEFAULT '',
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at      TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS callback_outbox_due_idx ON callback_outbox (status, next_attempt_at);
+
+CREATE TABLE IF NOT EXISTS callback_attempts (
+    id            SERIAL PRIMARY KEY,
+    outbox_id     INT         NOT NULL REFERENCES callback_outbox (id),
+    attempt       INT         NOT NULL,
+    response      TEXT        NOT NULL DEFAULT '',
+    error         TEXT        NOT NULL DEFAULT '',
+    duration_ms   BIGINT      NOT NULL DEFAULT 0,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS callback_attempts_outbox_idx ON callback_attempts (outbox_id);
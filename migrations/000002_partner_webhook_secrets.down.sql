@@ -0,0 +1,7 @@
+ALTER TABLE callback_outbox
+    DROP COLUMN IF EXISTS partner_id;
+
+ALTER TABLE partners
+    DROP COLUMN IF EXISTS previous_webhook_secret_expires_at,
+    DROP COLUMN IF EXISTS previous_webhook_secret,
+    DROP COLUMN IF EXISTS webhook_secret;
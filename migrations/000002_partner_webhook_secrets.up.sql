@@ -0,0 +1,7 @@
+ALTER TABLE partners
+    ADD COLUMN IF NOT EXISTS webhook_secret                     VARCHAR(64) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS previous_webhook_secret            VARCHAR(64) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS previous_webhook_secret_expires_at TIMESTAMPTZ;
+
+ALTER TABLE callback_outbox
+    ADD COLUMN IF NOT EXISTS partner_id INT NOT NULL DEFAULT 0;
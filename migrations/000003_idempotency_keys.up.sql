@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    id           SERIAL PRIMARY KEY,
+    partner_id   VARCHAR(64)  NOT NULL,
+    request_id   VARCHAR(255) NOT NULL,
+    operation    VARCHAR(32)  NOT NULL,
+    request_hash VARCHAR(64)  NOT NULL,
+    status       VARCHAR(20)  NOT NULL,
+    response     TEXT         NOT NULL DEFAULT '',
+    created_at   TIMESTAMPTZ  NOT NULL DEFAULT NOW(),
+    updated_at   TIMESTAMPTZ  NOT NULL DEFAULT NOW(),
+    UNIQUE (partner_id, request_id)
+);
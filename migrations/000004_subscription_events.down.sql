@@ -0,0 +1,4 @@
+UPDATE subscriptions SET status = 'A' WHERE status IN ('active', 'pending_deactivation', 'suspended');
+UPDATE subscriptions SET status = 'D' WHERE status IN ('deactivated', 'failed');
+
+DROP TABLE IF EXISTS subscription_events;
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS subscription_events (
+    id              SERIAL PRIMARY KEY,
+    subscription_id INT         NOT NULL REFERENCES subscriptions (id),
+    from_status     VARCHAR(32) NOT NULL DEFAULT '',
+    to_status       VARCHAR(32) NOT NULL,
+    source          VARCHAR(32) NOT NULL,
+    payload         TEXT        NOT NULL DEFAULT '',
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS subscription_events_subscription_idx ON subscription_events (subscription_id, created_at);
+
+-- move the free-form statuses onto the state machine
+UPDATE subscriptions SET status = 'active' WHERE status = 'A';
+UPDATE subscriptions SET status = 'deactivated' WHERE status = 'D';
+UPDATE subscriptions SET status = 'pending_activation'
+WHERE status NOT IN ('pending_activation', 'active', 'pending_deactivation', 'deactivated', 'failed', 'suspended');
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS plan_price_changes;
+
+ALTER TABLE plans
+    DROP COLUMN IF EXISTS archived_at;
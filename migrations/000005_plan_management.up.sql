@@ -0,0 +1,13 @@
+ALTER TABLE plans
+    ADD COLUMN IF NOT EXISTS archived_at TIMESTAMPTZ;
+
+CREATE TABLE IF NOT EXISTS plan_price_changes (
+    id         SERIAL PRIMARY KEY,
+    plan_id    VARCHAR(64)    NOT NULL REFERENCES plans (id),
+    old_cost   NUMERIC(12, 2) NOT NULL,
+    new_cost   NUMERIC(12, 2) NOT NULL,
+    changed_by VARCHAR(64)    NOT NULL,
+    created_at TIMESTAMPTZ    NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS plan_price_changes_plan_idx ON plan_price_changes (plan_id, created_at);
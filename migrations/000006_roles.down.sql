@@ -0,0 +1,5 @@
+ALTER TABLE partner_users
+    DROP COLUMN IF EXISTS role;
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS role;
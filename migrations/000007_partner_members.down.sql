@@ -0,0 +1,11 @@
+DROP INDEX IF EXISTS partner_users_member_idx;
+
+ALTER TABLE partner_users
+    DROP COLUMN IF EXISTS active;
+
+DROP INDEX IF EXISTS users_email_idx;
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS invite_expires_at,
+    DROP COLUMN IF EXISTS invite_token_hash,
+    DROP COLUMN IF EXISTS active;
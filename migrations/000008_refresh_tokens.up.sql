@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id         SERIAL PRIMARY KEY,
+    token_hash VARCHAR(64)  NOT NULL UNIQUE,
+    family_id  VARCHAR(64)  NOT NULL,
+    partner_id VARCHAR(64)  NOT NULL DEFAULT '',
+    user_id    VARCHAR(64)  NOT NULL DEFAULT '',
+    role       VARCHAR(20)  NOT NULL,
+    scope      VARCHAR(255) NOT NULL DEFAULT '',
+    expires_at TIMESTAMPTZ  NOT NULL,
+    revoked_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ  NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS refresh_tokens_family_idx ON refresh_tokens (family_id);
+
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    jti        VARCHAR(64) PRIMARY KEY,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
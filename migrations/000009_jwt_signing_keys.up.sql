@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS jwt_signing_keys (
+    kid         VARCHAR(64) PRIMARY KEY,
+    alg         VARCHAR(10) NOT NULL,
+    private_key TEXT        NOT NULL,
+    not_after   TIMESTAMPTZ NOT NULL,
+    expires_at  TIMESTAMPTZ NOT NULL,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS partner_api_keys (
+    id           SERIAL PRIMARY KEY,
+    partner_id   INT          NOT NULL REFERENCES partners (id),
+    name         VARCHAR(100) NOT NULL DEFAULT '',
+    prefix       VARCHAR(20)  NOT NULL UNIQUE,
+    key_hash     VARCHAR(64)  NOT NULL UNIQUE,
+    scope        VARCHAR(255) NOT NULL DEFAULT '',
+    last_used_at TIMESTAMPTZ,
+    expires_at   TIMESTAMPTZ,
+    revoked_at   TIMESTAMPTZ,
+    created_at   TIMESTAMPTZ  NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS partner_api_keys_partner_idx ON partner_api_keys (partner_id);
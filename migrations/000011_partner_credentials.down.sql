@@ -0,0 +1,4 @@
+ALTER TABLE partners
+    DROP COLUMN IF EXISTS reset_token_expires_at,
+    DROP COLUMN IF EXISTS reset_token_hash,
+    DROP COLUMN IF EXISTS secret_rotated_at;
@@ -0,0 +1,4 @@
+ALTER TABLE partners
+    ADD COLUMN IF NOT EXISTS secret_rotated_at       TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS reset_token_hash        VARCHAR(64) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS reset_token_expires_at  TIMESTAMPTZ;
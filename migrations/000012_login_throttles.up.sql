@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS login_throttles (
+    key             VARCHAR(255) PRIMARY KEY,
+    failures        INT          NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMPTZ  NOT NULL DEFAULT NOW(),
+    locked_until    TIMESTAMPTZ
+);
+
+CREATE TABLE IF NOT EXISTS login_attempts (
+    id         SERIAL PRIMARY KEY,
+    email      VARCHAR(255) NOT NULL DEFAULT '',
+    ip         VARCHAR(64)  NOT NULL DEFAULT '',
+    endpoint   VARCHAR(100) NOT NULL DEFAULT '',
+    reason     VARCHAR(100) NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ  NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS login_attempts_email_idx ON login_attempts (email, created_at);
+CREATE INDEX IF NOT EXISTS login_attempts_ip_idx ON login_attempts (ip, created_at);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS partner_daily_usage;
+DROP TABLE IF EXISTS partner_rate_limits;
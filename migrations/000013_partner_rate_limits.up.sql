@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS partner_rate_limits (
+    id              SERIAL PRIMARY KEY,
+    partner_id      INT              NOT NULL REFERENCES partners (id),
+    route           VARCHAR(255)     NOT NULL DEFAULT '*',
+    rate_per_second DOUBLE PRECISION NOT NULL,
+    burst           INT              NOT NULL,
+    daily_quota     INT              NOT NULL DEFAULT 0,
+    created_at      TIMESTAMPTZ      NOT NULL DEFAULT NOW(),
+    updated_at      TIMESTAMPTZ      NOT NULL DEFAULT NOW(),
+    UNIQUE (partner_id, route)
+);
+
+CREATE TABLE IF NOT EXISTS partner_daily_usage (
+    partner_id INT          NOT NULL,
+    route      VARCHAR(255) NOT NULL,
+    day        DATE         NOT NULL,
+    count      INT          NOT NULL DEFAULT 0,
+    PRIMARY KEY (partner_id, route, day)
+);
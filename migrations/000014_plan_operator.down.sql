@@ -0,0 +1,2 @@
+ALTER TABLE plans
+    DROP COLUMN IF EXISTS operator;
@@ -0,0 +1,2 @@
+ALTER TABLE plans
+    ADD COLUMN IF NOT EXISTS operator VARCHAR(50) NOT NULL DEFAULT '';
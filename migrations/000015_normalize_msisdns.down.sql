@@ -0,0 +1,2 @@
+-- The original formats of the MSISDNs are not kept, the normalised numbers stay.
+SELECT 1;
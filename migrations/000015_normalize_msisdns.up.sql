@@ -0,0 +1,5 @@
+-- Subscriptions were stored under the MSISDN exactly as partners sent it. Which country a national number belongs
+-- to depends on the configured operators, so the stored numbers are brought to E.164 digits by the application
+-- with the same rules as incoming requests (services.NormalizeStoredMsisdns), merging the subscriptions that turn
+-- out to be the same subscriber.
+SELECT 1;
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS sdp_notifications (
+    request_id   VARCHAR(255) PRIMARY KEY,
+    processed_at TIMESTAMPTZ  NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS sdp_notifications_processed_idx ON sdp_notifications (processed_at);
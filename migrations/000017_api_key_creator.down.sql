@@ -0,0 +1,2 @@
+ALTER TABLE partner_api_keys
+    DROP COLUMN IF EXISTS created_by_user_id;
@@ -0,0 +1,4 @@
+ALTER TABLE partner_api_keys
+    ADD COLUMN IF NOT EXISTS created_by_user_id INT REFERENCES users (id);
+
+CREATE INDEX IF NOT EXISTS partner_api_keys_creator_idx ON partner_api_keys (created_by_user_id);
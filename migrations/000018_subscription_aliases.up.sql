@@ -0,0 +1,11 @@
+-- Subscriptions merged while normalising MSISDNs leave their external_id behind, so that the SDP notifications
+-- and partner lookups carrying it still find the subscription they were merged into.
+CREATE TABLE IF NOT EXISTS subscription_aliases (
+    external_id     VARCHAR(255) NOT NULL,
+    plan_id         VARCHAR(64)  NOT NULL,
+    subscription_id INT          NOT NULL REFERENCES subscriptions (id),
+    created_at      TIMESTAMPTZ  NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (external_id, plan_id)
+);
+
+CREATE INDEX IF NOT EXISTS subscription_aliases_subscription_idx ON subscription_aliases (subscription_id);
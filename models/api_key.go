@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// APIKey: This structure is a long-lived partner credential sent in the X-Api-Key header instead of a Bearer token.
+// Only the hash of the key is kept, Prefix is the public part of the key used to tell keys apart.
+// CreatedByUserID is the user who created the key, nil when it was created with the partner secret.
+type APIKey struct {
+	ID              uint       `json:"id" gorm:"column:id;primarykey;<-:false;autoIncrement;type:int"`
+	PartnerID       uint       `json:"partner_id" gorm:"column:partner_id"`
+	Name            string     `json:"name" gorm:"column:name"`
+	Prefix          string     `json:"prefix" gorm:"column:prefix"`
+	KeyHash         string     `json:"-" gorm:"column:key_hash"`
+	Scope           string     `json:"scope" gorm:"column:scope"`
+	CreatedByUserID *uint      `json:"created_by_user_id" gorm:"column:created_by_user_id"`
+	LastUsedAt      *time.Time `json:"last_used_at" gorm:"column:last_used_at"`
+	ExpiresAt       *time.Time `json:"expires_at" gorm:"column:expires_at"`
+	RevokedAt       *time.Time `json:"revoked_at" gorm:"column:revoked_at"`
+	CreatedAt       time.Time  `json:"created_at" gorm:"column:created_at"`
+}
+
+// APIKeyRequest: This structure is the body of the create API key endpoint. Scope is a space separated subset of
+// the caller's scopes, subscriptions:write and charge:write when empty. ExpiresAt is optional.
+type APIKeyRequest struct {
+	Name      string     `json:"name" binding:"required,max=100"`
+	Scope     string     `json:"scope"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
@@ -0,0 +1,52 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Delivery states of an outbound partner callback.
+const (
+	CallbackPending    = "pending"
+	CallbackDelivered  = "delivered"
+	CallbackDeadLetter = "dead_letter"
+)
+
+// CallbackOutbox: This structure represents a notification that has to be forwarded to a partner's callback URL.
+// Rows are written in the same database transaction as the subscription/transaction update they describe
+// and are picked up by the callback dispatcher.
+type CallbackOutbox struct {
+	ID            uint      `json:"id" gorm:"column:id;primarykey;<-:false;autoIncrement;type:int"`
+	PartnerID     uint      `json:"partner_id" gorm:"column:partner_id"`
+	Url           string    `json:"url" gorm:"column:url"`
+	Payload       string    `json:"payload" gorm:"column:payload"`
+	Status        string    `json:"status" gorm:"column:status"`
+	Attempts      int       `json:"attempts" gorm:"column:attempts"`
+	MaxAttempts   int       `json:"max_attempts" gorm:"column:max_attempts"`
+	NextAttemptAt time.Time `json:"next_attempt_at" gorm:"column:next_attempt_at"`
+	LastError     string    `json:"last_error" gorm:"column:last_error"`
+	CreatedAt     time.Time `json:"created_at" gorm:"column:created_at"`
+	UpdatedAt     time.Time `json:"updated_at" gorm:"column:updated_at"`
+}
+
+// CallbackAttempt: This structure records a single delivery attempt of a CallbackOutbox entry.
+type CallbackAttempt struct {
+	ID        uint      `json:"id" gorm:"column:id;primarykey;<-:false;autoIncrement;type:int"`
+	OutboxID  uint      `json:"outbox_id" gorm:"column:outbox_id"`
+	Attempt   int       `json:"attempt" gorm:"column:attempt"`
+	Response  string    `json:"response" gorm:"column:response"`
+	Error     string    `json:"error" gorm:"column:error"`
+	Duration  int64     `json:"duration_ms" gorm:"column:duration_ms"`
+	CreatedAt time.Time `json:"created_at" gorm:"column:created_at"`
+}
+
+// This function queues a callback inside the given database transaction so that it is only
+// persisted if the change it describes is committed.
+func (cb *CallbackOutbox) Enqueue(tx *gorm.DB) error {
+	cb.Status = CallbackPending
+	if cb.NextAttemptAt.IsZero() {
+		cb.NextAttemptAt = time.Now()
+	}
+	return tx.Debug().Table("callback_outbox").Create(cb).Error
+}
@@ -2,9 +2,6 @@ package models
 
 import (
 	"time"
-
-	"github.com/sirupsen/logrus"
-	"github.com/apeli23/infinity/database"
 )
 // User: This structure represents a user of the application.
 type User struct {
@@ -14,9 +11,15 @@ type User struct {
 	FirstName string    `json:"firstname" gorm:"column:firstname"`
 	LastName  string    `json:"lastname" gorm:"column:lastname"`
 	Email     string    `json:"email" gorm:"column:email"`
-	Password  string    `json:"password" gorm:"column:password"`
+	Password  string    `json:"-" gorm:"column:password"`
+	Role      string    `json:"role" gorm:"column:role"`
+	Active    bool      `json:"active" gorm:"column:active"`
 	CreatedAt time.Time `json:"created_at" gorm:"column:created_at"`
 	UpdatedAt time.Time `json:"updated_at" gorm:"column:updated_at"`
+
+	// invited users set their password with this one-time token, only its hash is stored
+	InviteTokenHash string     `json:"-" gorm:"column:invite_token_hash"`
+	InviteExpiresAt *time.Time `json:"-" gorm:"column:invite_expires_at"`
 }
 
 //PartnerUsers: This structure represents the relationship between a partner and a user. It contains fields such as the ID of the user and the ID of the partner.
@@ -24,6 +27,8 @@ type PartnerUsers struct {
 	ID        uint      `json:"id" gorm:"column:id;primarykey;<-:false;autoIncrement;type:int"`
 	UserID    uint      `json:"user_id" gorm:"column:user_id"`
 	PartnerID uint      `json:"partner_id" gorm:"column:partner_id"`
+	Role      string    `json:"role" gorm:"column:role"`
+	Active    bool      `json:"active" gorm:"column:active"`
 	CreatedAt time.Time `json:"created_at" gorm:"column:created_at"`
 	UpdatedAt time.Time `json:"updated_at" gorm:"column:updated_at"`
 }
@@ -37,17 +42,30 @@ type Partner struct {
 	PhoneNumber string    `json:"phone_number" gorm:"column:phone_number"`
 	CreatedAt   time.Time `json:"created_at" gorm:"column:created_at"`
 	UpdatedAt   time.Time `json:"updated_at" gorm:"column:updated_at"`
+
+	// secrets used to sign the callbacks forwarded to the partner, the previous one stays valid until it expires
+	WebhookSecret                  string     `json:"-" gorm:"column:webhook_secret"`
+	PreviousWebhookSecret          string     `json:"-" gorm:"column:previous_webhook_secret"`
+	PreviousWebhookSecretExpiresAt *time.Time `json:"-" gorm:"column:previous_webhook_secret_expires_at"`
+
+	// one-time token an admin issues so the partner can get a new secret, only its hash is kept
+	SecretRotatedAt     *time.Time `json:"secret_rotated_at" gorm:"column:secret_rotated_at"`
+	ResetTokenHash      string     `json:"-" gorm:"column:reset_token_hash"`
+	ResetTokenExpiresAt *time.Time `json:"-" gorm:"column:reset_token_expires_at"`
 }
 
 //Plan: This structure represents a plan that a user can subscribe to. 
+// The ID is the offer code partners send in HeRequest.OfferCode, so it is set by whoever creates the plan.
 type Plan struct {
-	ID        string    `json:"id" gorm:"column:id;primarykey;<-:false;autoIncrement;type:int"`
-	Name      string    `json:"name" gorm:"column:name"`
-	Amount    float64   `json:"amount" gorm:"column:cost"`
-	Cycle     string    `json:"cycle" gorm:"column:frequency"`
-	CreatedAt time.Time `json:"created_at" gorm:"column:created_at"`
-	UpdatedAt time.Time `json:"updated_at" gorm:"column:updated_at"`
-	PartnerID uint      `json:"partner" gorm:"column:partner_id"`
+	ID         string     `json:"id" gorm:"column:id;primarykey"`
+	Name       string     `json:"name" gorm:"column:name"`
+	Amount     float64    `json:"amount" gorm:"column:cost"`
+	Cycle      string     `json:"cycle" gorm:"column:frequency"`
+	CreatedAt  time.Time  `json:"created_at" gorm:"column:created_at"`
+	UpdatedAt  time.Time  `json:"updated_at" gorm:"column:updated_at"`
+	PartnerID  uint       `json:"partner" gorm:"column:partner_id"`
+	ArchivedAt *time.Time `json:"archived_at" gorm:"column:archived_at"`
+	Operator   string     `json:"operator" gorm:"column:operator"`
 }
 
 //Subscription: This structure represents a user's subscription to a plan.
@@ -64,6 +82,14 @@ type Subscription struct {
 	UpdatedAt         time.Time `json:"updated_at" gorm:"column:updated_at"`
 }
 
+// SubscriptionAlias: another external_id of a subscription, left by a subscription merged into it.
+type SubscriptionAlias struct {
+	ExternalID     string    `json:"external_id" gorm:"column:external_id"`
+	PlanID         string    `json:"plan" gorm:"column:plan_id"`
+	SubscriptionID uint      `json:"subscription" gorm:"column:subscription_id"`
+	CreatedAt      time.Time `json:"created_at" gorm:"column:created_at"`
+}
+
 //Transaction: This structure represents a transaction that occurs when a user's airtime is charged.
 type Transaction struct {
 	ID                uint      `json:"id" gorm:"column:id;primarykey;<-:false;autoIncrement;type:int"`
@@ -81,6 +107,13 @@ type Transaction struct {
 type Login struct {
 	Username string `json:"username" binding:"required"`
 	Password string `json:"password" binding:"required"`
+	// optional space separated scopes to narrow the token down to
+	Scope string `json:"scope"`
+}
+
+// SecretReset: This structure is the body of the endpoint a partner exchanges an admin issued reset token for a new secret with.
+type SecretReset struct {
+	Token string `json:"token" binding:"required"`
 }
 
 //HeRequest: This structure represents a request to the Safaricom SDP to charge a user's airtime.
@@ -106,7 +139,7 @@ type HeResponseBody struct {
 	Status      string      `json:"status" binding:"required"`
 	Description string      `json:"description" binding:"required"`
 	StatusCode  string      `json:"statusCode"`
-	Data        []dataItems `json:"data"`
+	Data        []DataItem  `json:"data"`
 }
 
 //HeResponse struct: This struct defines the response of an API that returns two objects - HeResponseHeader and HeResponseBody. 
@@ -116,48 +149,19 @@ type HeResponse struct {
 	Body   HeResponseBody   `json:"body" binding:"required"`
 }
 
-//This function is defined on a Subscription struct and it saves a subscription record to the database
-func (sub *Subscription) SaveSubscription() (*Subscription, error) {
-//It first tries to update an existing subscription record by matching the plan ID and MSISDN, 
-// Debug() method on the Db object is used to enable debug logging for the query.
-	result := database.Db.Debug().Table("subscriptions").Where("plan_id = ? AND msisdn = ?", sub.PlanID, sub.MSISDN).Updates(&sub)
-	if result.Error != nil {
-		return sub, result.Error
-// if no record is found, it creates a new subscription record. 
-//The RowsAffected property of the result object is used to check if any rows were affected by the update query.		
-	} else if result.RowsAffected == 0 {
-		if err := database.Db.Debug().Table("subscriptions").Save(&sub).Error; err != nil {
-			logrus.Error(err)
-			return sub, err
-		}
-	}
-
-	return sub, nil
-}
-
-//This function is defined on a Transaction struct and it saves a transaction record to the database
-func (trx *Transaction) SaveTransaction() (*Transaction, error) {
-//It simply calls the Save() method on the transactions table and returns the result.
-	if err := database.Db.Debug().Table("transactions").Save(&trx).Error; err != nil {
-		logrus.Error(err)
-		return trx, err
-	}
-	return trx, nil
-}
-
 //This struct represents a callback object that is received by an API
 type Callback struct {
-	RequestId    string       `json:"requestId"  binding:"required"`
-	RequestParam requestParam `json:"requestParam"  binding:"required"`
+	RequestId    string         `json:"requestId"  binding:"required"`
+	RequestParam CallbackParams `json:"requestParam"  binding:"required"`
 }
 
-// This struct represents the requestParam field of the Callback struct. It contains a Data field that is an array of dataItems.
-type requestParam struct {
-	Data []dataItems `json:"data"  binding:"required"`
+// This struct represents the requestParam field of the Callback struct. It contains a Data field that is an array of DataItem.
+type CallbackParams struct {
+	Data []DataItem `json:"data"  binding:"required"`
 }
 
-//This struct represents an item in the Data array of the requestParam struct
-type dataItems struct {
+//This struct represents an item in the Data array of the CallbackParams struct
+type DataItem struct {
 	Name  string      `json:"name"  binding:"required"`
 	Value interface{} `json:"value"  binding:"required"`
 }
@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// States of an idempotency key. Failed keys hold the error the SDP refused the request with, unknown keys are
+// requests the SDP may or may not have applied, which are not sent again.
+const (
+	IdempotencyProcessing = "processing"
+	IdempotencyCompleted  = "completed"
+	IdempotencyFailed     = "failed"
+	IdempotencyUnknown    = "unknown"
+)
+
+// IdempotencyKey: This structure records the first outcome of a partner request so that retries carrying the same
+// requestId replay it instead of hitting the SDP again. Keys are unique per partner.
+type IdempotencyKey struct {
+	ID          uint      `json:"id" gorm:"column:id;primarykey;<-:false;autoIncrement;type:int"`
+	PartnerID   string    `json:"partner_id" gorm:"column:partner_id"`
+	RequestID   string    `json:"request_id" gorm:"column:request_id"`
+	Operation   string    `json:"operation" gorm:"column:operation"`
+	RequestHash string    `json:"request_hash" gorm:"column:request_hash"`
+	Status      string    `json:"status" gorm:"column:status"`
+	Response    string    `json:"response" gorm:"column:response"`
+	CreatedAt   time.Time `json:"created_at" gorm:"column:created_at"`
+	UpdatedAt   time.Time `json:"updated_at" gorm:"column:updated_at"`
+}
@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+// JWTSigningKey: This structure is a key pair access tokens are signed with, identified in tokens by the kid header.
+// A key signs new tokens until NotAfter and is published in the JWKS until ExpiresAt, when the last token it signed has expired.
+type JWTSigningKey struct {
+	KID        string    `json:"kid" gorm:"column:kid;primarykey"`
+	Alg        string    `json:"alg" gorm:"column:alg"`
+	PrivateKey string    `json:"-" gorm:"column:private_key"`
+	NotAfter   time.Time `json:"not_after" gorm:"column:not_after"`
+	ExpiresAt  time.Time `json:"expires_at" gorm:"column:expires_at"`
+	CreatedAt  time.Time `json:"created_at" gorm:"column:created_at"`
+}
+
+// JWK: This structure is a public key in JSON Web Key format (RFC 7517), EC and RSA members are filled per key type.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+// JWKS: This structure is the document served on /public/.well-known/jwks.json.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// LoginThrottle: This structure counts the recent failed logins of one email or IP address, Key is "email:<email>" or "ip:<ip>".
+type LoginThrottle struct {
+	Key           string     `json:"key" gorm:"column:key;primarykey"`
+	Failures      int        `json:"failures" gorm:"column:failures"`
+	LastFailureAt time.Time  `json:"last_failure_at" gorm:"column:last_failure_at"`
+	LockedUntil   *time.Time `json:"locked_until" gorm:"column:locked_until"`
+}
+
+// LoginAttempt: This structure is the audit record of a failed login.
+type LoginAttempt struct {
+	ID        uint      `json:"id" gorm:"column:id;primarykey;<-:false;autoIncrement;type:int"`
+	Email     string    `json:"email" gorm:"column:email"`
+	IP        string    `json:"ip" gorm:"column:ip"`
+	Endpoint  string    `json:"endpoint" gorm:"column:endpoint"`
+	Reason    string    `json:"reason" gorm:"column:reason"`
+	CreatedAt time.Time `json:"created_at" gorm:"column:created_at"`
+}
+
+// LoginUnlock: This structure is the body of the admin unlock endpoint, either field clears the matching lockout.
+type LoginUnlock struct {
+	Email string `json:"email"`
+	IP    string `json:"ip"`
+}
@@ -0,0 +1,128 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SubscriptionNotification: This structure is the data of an SDP activation/deactivation notification.
+type SubscriptionNotification struct {
+	ClientTransactionId string
+	OfferCode           string
+	Msisdn              string
+	SubscriptionStatus  string
+}
+
+// ChargeNotification: This structure is the data of an SDP charge notification.
+type ChargeNotification struct {
+	ClientTransactionId string
+	OfferCode           string
+	Msisdn              string
+	ChargeAmount        string
+	Reason              string
+}
+
+// NotificationError lists what is wrong with the data items of an SDP notification, it is answered to the SDP.
+type NotificationError struct {
+	Problems []string `json:"problems"`
+}
+
+func (e *NotificationError) Error() string {
+	return fmt.Sprintf("invalid notification data: %s", strings.Join(e.Problems, "; "))
+}
+
+// Text returns the value of the item as a string. The SDP sends some values as JSON numbers or booleans,
+// those are formatted as it would have written them; objects, arrays and null are refused.
+func (d DataItem) Text() (string, error) {
+	switch value := d.Value.(type) {
+	case string:
+		return value, nil
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), nil
+	case json.Number:
+		return value.String(), nil
+	case bool:
+		return strconv.FormatBool(value), nil
+	case nil:
+		return "", fmt.Errorf("%s is null", d.Name)
+	default:
+		return "", fmt.Errorf("%s is not a string or a number", d.Name)
+	}
+}
+
+// notificationField is a data item a notification is decoded into.
+type notificationField struct {
+	target   *string
+	required bool
+}
+
+// decodeNotification fills the fields from the data items by name. Items we do not know are ignored, as the SDP
+// may add some, while missing required items, empty ones and values that are not scalars are reported together.
+func decodeNotification(params CallbackParams, fields map[string]notificationField) error {
+	problems := []string{}
+	seen, failed := map[string]bool{}, map[string]bool{}
+	for _, item := range params.Data {
+		field, ok := fields[item.Name]
+		if !ok {
+			continue
+		}
+		if seen[item.Name] {
+			problems = append(problems, fmt.Sprintf("%s is repeated", item.Name))
+			continue
+		}
+		seen[item.Name] = true
+
+		value, err := item.Text()
+		if err != nil {
+			problems = append(problems, err.Error())
+			failed[item.Name] = true
+			continue
+		}
+		*field.target = strings.TrimSpace(value)
+	}
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		switch field := fields[name]; {
+		case !field.required, failed[name]:
+		case !seen[name]:
+			problems = append(problems, fmt.Sprintf("%s is missing", name))
+		case *field.target == "":
+			problems = append(problems, fmt.Sprintf("%s is empty", name))
+		}
+	}
+
+	if len(problems) > 0 {
+		return &NotificationError{Problems: problems}
+	}
+	return nil
+}
+
+// DecodeSubscriptionNotification reads the data items of an activation/deactivation notification.
+func DecodeSubscriptionNotification(params CallbackParams) (notification SubscriptionNotification, err error) {
+	err = decodeNotification(params, map[string]notificationField{
+		"ClientTransactionId": {target: &notification.ClientTransactionId, required: true},
+		"OfferCode":           {target: &notification.OfferCode, required: true},
+		"Msisdn":              {target: &notification.Msisdn},
+		"SubscriptionStatus":  {target: &notification.SubscriptionStatus, required: true},
+	})
+	return
+}
+
+// DecodeChargeNotification reads the data items of a charge notification.
+func DecodeChargeNotification(params CallbackParams) (notification ChargeNotification, err error) {
+	err = decodeNotification(params, map[string]notificationField{
+		"ClientTransactionId": {target: &notification.ClientTransactionId, required: true},
+		"OfferCode":           {target: &notification.OfferCode, required: true},
+		"Msisdn":              {target: &notification.Msisdn},
+		"ChargeAmount":        {target: &notification.ChargeAmount},
+		"Reason":              {target: &notification.Reason, required: true},
+	})
+	return
+}
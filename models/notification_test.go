@@ -0,0 +1,211 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// callbackParams decodes the requestParam of a notification as the SDP posts it.
+func callbackParams(t *testing.T, body string) CallbackParams {
+	t.Helper()
+	params := CallbackParams{}
+	if err := json.Unmarshal([]byte(body), &params); err != nil {
+		t.Fatal(err)
+	}
+	return params
+}
+
+func TestDataItemText(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  string
+		fails bool
+	}{
+		{name: "string", value: `"254712345678"`, want: "254712345678"},
+		{name: "empty string", value: `""`, want: ""},
+		{name: "integer", value: `254712345678`, want: "254712345678"},
+		{name: "decimal", value: `10.5`, want: "10.5"},
+		{name: "large integer", value: `1e21`, want: "1000000000000000000000"},
+		{name: "boolean", value: `true`, want: "true"},
+		{name: "null", value: `null`, fails: true},
+		{name: "object", value: `{"value": "1"}`, fails: true},
+		{name: "array", value: `["1"]`, fails: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			item := DataItem{}
+			if err := json.Unmarshal([]byte(`{"name": "Msisdn", "value": `+test.value+`}`), &item); err != nil {
+				t.Fatal(err)
+			}
+			got, err := item.Text()
+			if test.fails {
+				if err == nil {
+					t.Fatalf("Text() = %q, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Text() error = %v", err)
+			}
+			if got != test.want {
+				t.Errorf("Text() = %q, want %q", got, test.want)
+			}
+		})
+	}
+
+	if got, err := (DataItem{Name: "ChargeAmount", Value: json.Number("10")}).Text(); err != nil || got != "10" {
+		t.Errorf("Text() of a json.Number = %q, %v", got, err)
+	}
+}
+
+func TestDecodeSubscriptionNotification(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		want     SubscriptionNotification
+		problems []string
+	}{
+		{
+			name: "complete",
+			body: `{"data": [
+				{"name": "ClientTransactionId", "value": "c1"},
+				{"name": "OfferCode", "value": "001029900655"},
+				{"name": "Msisdn", "value": 254712345678},
+				{"name": "SubscriptionStatus", "value": " A "}
+			]}`,
+			want: SubscriptionNotification{ClientTransactionId: "c1", OfferCode: "001029900655", Msisdn: "254712345678", SubscriptionStatus: "A"},
+		},
+		{
+			name: "unknown names are ignored",
+			body: `{"data": [
+				{"name": "ClientTransactionId", "value": "c1"},
+				{"name": "OfferCode", "value": "001029900655"},
+				{"name": "SubscriptionStatus", "value": "D"},
+				{"name": "OfferName", "value": {"en": "Daily news"}},
+				{"name": "clienttransactionid", "value": null}
+			]}`,
+			want: SubscriptionNotification{ClientTransactionId: "c1", OfferCode: "001029900655", SubscriptionStatus: "D"},
+		},
+		{
+			name:     "no items",
+			body:     `{"data": []}`,
+			problems: []string{"ClientTransactionId is missing", "OfferCode is missing", "SubscriptionStatus is missing"},
+		},
+		{
+			name: "missing and empty items",
+			body: `{"data": [
+				{"name": "ClientTransactionId", "value": "c1"},
+				{"name": "OfferCode", "value": "  "}
+			]}`,
+			problems: []string{"OfferCode is empty", "SubscriptionStatus is missing"},
+		},
+		{
+			name: "wrong types",
+			body: `{"data": [
+				{"name": "ClientTransactionId", "value": null},
+				{"name": "OfferCode", "value": ["001029900655"]},
+				{"name": "Msisdn", "value": {"number": "254712345678"}},
+				{"name": "SubscriptionStatus", "value": "A"}
+			]}`,
+			problems: []string{"ClientTransactionId is null", "OfferCode is not a string or a number", "Msisdn is not a string or a number"},
+		},
+		{
+			name: "repeated item",
+			body: `{"data": [
+				{"name": "ClientTransactionId", "value": "c1"},
+				{"name": "ClientTransactionId", "value": "c2"},
+				{"name": "OfferCode", "value": "001029900655"},
+				{"name": "SubscriptionStatus", "value": "A"}
+			]}`,
+			problems: []string{"ClientTransactionId is repeated"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := DecodeSubscriptionNotification(callbackParams(t, test.body))
+			checkNotificationProblems(t, err, test.problems)
+			if test.problems == nil && got != test.want {
+				t.Errorf("DecodeSubscriptionNotification() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDecodeChargeNotification(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		want     ChargeNotification
+		problems []string
+	}{
+		{
+			name: "complete",
+			body: `{"data": [
+				{"name": "ClientTransactionId", "value": "c1"},
+				{"name": "OfferCode", "value": "001029900655"},
+				{"name": "Msisdn", "value": "254712345678"},
+				{"name": "ChargeAmount", "value": 10.5},
+				{"name": "Reason", "value": "Success"}
+			]}`,
+			want: ChargeNotification{ClientTransactionId: "c1", OfferCode: "001029900655", Msisdn: "254712345678", ChargeAmount: "10.5", Reason: "Success"},
+		},
+		{
+			name: "optional items missing and unknown names",
+			body: `{"data": [
+				{"name": "ClientTransactionId", "value": "c1"},
+				{"name": "OfferCode", "value": 1029},
+				{"name": "Reason", "value": "Insufficient funds"},
+				{"name": "BalanceAfter", "value": [1, 2]}
+			]}`,
+			want: ChargeNotification{ClientTransactionId: "c1", OfferCode: "1029", Reason: "Insufficient funds"},
+		},
+		{
+			name:     "no items",
+			body:     `{"data": []}`,
+			problems: []string{"ClientTransactionId is missing", "OfferCode is missing", "Reason is missing"},
+		},
+		{
+			name: "wrong types",
+			body: `{"data": [
+				{"name": "ClientTransactionId", "value": "c1"},
+				{"name": "OfferCode", "value": "001029900655"},
+				{"name": "ChargeAmount", "value": {"amount": 10}},
+				{"name": "Reason", "value": null}
+			]}`,
+			problems: []string{"ChargeAmount is not a string or a number", "Reason is null"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := DecodeChargeNotification(callbackParams(t, test.body))
+			checkNotificationProblems(t, err, test.problems)
+			if test.problems == nil && got != test.want {
+				t.Errorf("DecodeChargeNotification() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+// checkNotificationProblems checks that err is nil when problems is, or else a NotificationError listing them.
+func checkNotificationProblems(t *testing.T, err error, problems []string) {
+	t.Helper()
+	if problems == nil {
+		if err != nil {
+			t.Fatalf("error = %v", err)
+		}
+		return
+	}
+	var notificationErr *NotificationError
+	if !errors.As(err, &notificationErr) {
+		t.Fatalf("error = %v, want a NotificationError", err)
+	}
+	if !reflect.DeepEqual(notificationErr.Problems, problems) {
+		t.Errorf("problems = %q, want %q", notificationErr.Problems, problems)
+	}
+}
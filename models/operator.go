@@ -0,0 +1,28 @@
+package models
+
+// Operator: This structure is the configuration of a mobile network we reach through its SDP: where and with which
+// credentials to call it, the headers it expects and the MSISDN prefixes of its subscribers.
+// Prefixes are in international format without the "+"; an operator without prefixes takes the MSISDNs no other operator claims.
+type Operator struct {
+	Code        string   `json:"code"`
+	Name        string   `json:"name"`
+	CountryCode string   `json:"country_code"`
+	Prefixes    []string `json:"prefixes"`
+
+	BaseURL     string `json:"base_url"`
+	HeAuthURL   string `json:"he_auth_url"`
+	HeUsername  string `json:"he_username"`
+	HePassword  string `json:"he_password"`
+	SdpAuthURL  string `json:"sdp_auth_url"`
+	SdpUsername string `json:"sdp_username"`
+	SdpPassword string `json:"sdp_password"`
+	CPID        string `json:"cpid"`
+	APIKey      string `json:"api_key"`
+
+	// ActDeactCallback and ChargeCallback are the callback URLs given to the operator's SDP
+	ActDeactCallback string `json:"act_deact_callback"`
+	ChargeCallback   string `json:"charge_callback"`
+
+	// Headers override or add to the default SDP request headers
+	Headers map[string]string `json:"headers"`
+}
@@ -0,0 +1,65 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrInvalidOfferCode = errors.New("offer_code must be 1 to 64 letters, digits, - or _")
+
+// Billing cycles a plan can be charged on.
+const (
+	CycleDaily   = "daily"
+	CycleWeekly  = "weekly"
+	CycleMonthly = "monthly"
+)
+
+// PlanRequest: This structure is the body of the plan create endpoint, updates use PlanUpdate.
+// PartnerID may only differ from the caller's own partner for admins.
+type PlanRequest struct {
+	OfferCode string  `json:"offer_code" binding:"required,max=64"`
+	Name      string  `json:"name" binding:"required"`
+	Amount    float64 `json:"amount" binding:"gte=0"`
+	Cycle     string  `json:"cycle" binding:"required,oneof=daily weekly monthly"`
+	PartnerID uint    `json:"partner" binding:"-"`
+	// Operator binds the plan to one operator's subscribers, empty plans follow the MSISDN's routing
+	Operator string `json:"operator" binding:"omitempty,max=50"`
+}
+
+// Validate checks what binding cannot: the offer code is sent to the SDP with every request of the plan.
+func (r PlanRequest) Validate() error {
+	if !ValidOfferCode(r.OfferCode) {
+		return ErrInvalidOfferCode
+	}
+	return nil
+}
+
+// ValidOfferCode reports whether code can be sent to the SDP as an offer code: 1 to 64 letters, digits, - or _.
+func ValidOfferCode(code string) bool {
+	if code == "" || len(code) > 64 {
+		return false
+	}
+	for _, c := range code {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// PlanUpdate: This structure is the body of the plan update endpoint, omitted fields are left unchanged.
+type PlanUpdate struct {
+	Name   *string  `json:"name" binding:"omitempty,min=1"`
+	Amount *float64 `json:"amount" binding:"omitempty,gte=0"`
+	Cycle  *string  `json:"cycle" binding:"omitempty,oneof=daily weekly monthly"`
+}
+
+// PlanPriceChange: This structure is an audit record of a change to a plan's cost.
+type PlanPriceChange struct {
+	ID        uint      `json:"id" gorm:"column:id;primarykey;<-:false;autoIncrement;type:int"`
+	PlanID    string    `json:"plan" gorm:"column:plan_id"`
+	OldAmount float64   `json:"old_amount" gorm:"column:old_cost"`
+	NewAmount float64   `json:"new_amount" gorm:"column:new_cost"`
+	ChangedBy string    `json:"changed_by" gorm:"column:changed_by"`
+	CreatedAt time.Time `json:"created_at" gorm:"column:created_at"`
+}
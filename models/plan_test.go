@@ -0,0 +1,41 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestPlanRequestValidate(t *testing.T) {
+	tests := []struct {
+		offerCode string
+		valid     bool
+	}{
+		{offerCode: "001029900655", valid: true},
+		{offerCode: "daily_news-01", valid: true},
+		{offerCode: strings.Repeat("A", 64), valid: true},
+		{offerCode: ""},
+		{offerCode: strings.Repeat("A", 65)},
+		{offerCode: "001 029"},
+		{offerCode: `0010"29`},
+		{offerCode: "1'; DROP TABLE plans;--"},
+		{offerCode: "<script>"},
+		{offerCode: "offre-été"},
+	}
+
+	for _, test := range tests {
+		request := PlanRequest{OfferCode: test.offerCode, Name: "Daily news", Cycle: CycleDaily}
+		err := request.Validate()
+		if test.valid && err != nil {
+			t.Errorf("Validate() of offer code %q error = %v", test.offerCode, err)
+		}
+		if !test.valid && !errors.Is(err, ErrInvalidOfferCode) {
+			t.Errorf("Validate() of offer code %q error = %v, want %v", test.offerCode, err, ErrInvalidOfferCode)
+		}
+		// plans are only created with offer codes the SDP requests accept
+		sdpErr := SdpSubscriptionRequest{Msisdn: "254712345678", OfferCode: test.offerCode, CpId: "1029", CallBackUrl: "https://he.example.com"}.Validate()
+		if (sdpErr == nil) != test.valid {
+			t.Errorf("SDP request with offer code %q error = %v, plan valid = %v", test.offerCode, sdpErr, test.valid)
+		}
+	}
+}
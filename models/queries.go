@@ -0,0 +1,47 @@
+package models
+
+import "time"
+
+// SubscriptionFilter: This structure holds the query string filters of the partner subscription listing.
+type SubscriptionFilter struct {
+	PlanID string    `form:"plan"`
+	Msisdn string    `form:"msisdn"`
+	Status string    `form:"status"`
+	From   time.Time `form:"from" time_format:"2006-01-02T15:04:05Z07:00"`
+	To     time.Time `form:"to" time_format:"2006-01-02T15:04:05Z07:00"`
+	Cursor string    `form:"cursor"`
+	Limit  int       `form:"limit"`
+}
+
+// SubscriptionDetails: This structure is a subscription together with the transactions charged against it.
+type SubscriptionDetails struct {
+	Subscription Subscription  `json:"subscription"`
+	Transactions []Transaction `json:"transactions"`
+}
+
+// TransactionFilter: This structure holds the query string filters of the partner transaction listing, totals and export.
+type TransactionFilter struct {
+	PlanID    string    `form:"plan"`
+	Msisdn    string    `form:"msisdn"`
+	Status    string    `form:"status"`
+	From      time.Time `form:"from" time_format:"2006-01-02T15:04:05Z07:00"`
+	To        time.Time `form:"to" time_format:"2006-01-02T15:04:05Z07:00"`
+	MinAmount *float64  `form:"min_amount"`
+	MaxAmount *float64  `form:"max_amount"`
+	Cursor    string    `form:"cursor"`
+	Limit     int       `form:"limit"`
+}
+
+// TransactionRecord: This structure is a transaction with the plan and MSISDN of the subscription it was charged against.
+type TransactionRecord struct {
+	Transaction `gorm:"embedded"`
+	PlanID      string `json:"plan" gorm:"column:plan_id"`
+	MSISDN      string `json:"msisdn" gorm:"column:msisdn"`
+}
+
+// TransactionTotals: This structure aggregates the transactions matching a filter per status.
+type TransactionTotals struct {
+	Status string  `json:"status" gorm:"column:status"`
+	Count  int64   `json:"count" gorm:"column:count"`
+	Amount float64 `json:"amount" gorm:"column:amount"`
+}
@@ -0,0 +1,33 @@
+package models
+
+import "time"
+
+// RateLimit: This structure is the request rate and daily quota of a partner on a route. Route is the gin route path
+// or "*" for every rate limited route without a row of its own. A DailyQuota of 0 means no quota.
+type RateLimit struct {
+	ID            uint      `json:"id" gorm:"column:id;primarykey;<-:false;autoIncrement;type:int"`
+	PartnerID     uint      `json:"partner_id" gorm:"column:partner_id"`
+	Route         string    `json:"route" gorm:"column:route"`
+	RatePerSecond float64   `json:"rate_per_second" gorm:"column:rate_per_second"`
+	Burst         int       `json:"burst" gorm:"column:burst"`
+	DailyQuota    int       `json:"daily_quota" gorm:"column:daily_quota"`
+	CreatedAt     time.Time `json:"created_at" gorm:"column:created_at"`
+	UpdatedAt     time.Time `json:"updated_at" gorm:"column:updated_at"`
+}
+
+// RateLimitRequest: This structure is the body of the endpoint admins set a partner's limits with.
+type RateLimitRequest struct {
+	Route         string  `json:"route" binding:"required"`
+	RatePerSecond float64 `json:"rate_per_second" binding:"required,gt=0"`
+	Burst         int     `json:"burst" binding:"required,min=1"`
+	DailyQuota    int     `json:"daily_quota" binding:"min=0"`
+}
+
+// RateLimitStatus: This structure is the outcome of a rate limit check, it fills the rate limit response headers.
+type RateLimitStatus struct {
+	Limit          int
+	Remaining      int
+	QuotaLimit     int
+	QuotaRemaining int
+	RetryAfter     time.Duration
+}
@@ -0,0 +1,25 @@
+package models
+
+// Roles carried in the access token. Admins manage the platform, partners manage their own plans and subscribers
+// and read-only members of a partner can only query.
+const (
+	RoleAdmin    = "admin"
+	RolePartner  = "partner"
+	RoleReadOnly = "read_only"
+)
+
+// Scopes an access token can be limited to. Routes list the scopes they require.
+const (
+	ScopeSubscriptionsRead  = "subscriptions:read"
+	ScopeSubscriptionsWrite = "subscriptions:write"
+	ScopeChargeWrite        = "charge:write"
+	ScopeTransactionsRead   = "transactions:read"
+	ScopePlansRead          = "plans:read"
+	ScopePlansWrite         = "plans:write"
+	ScopeUsersRead          = "users:read"
+	ScopeUsersWrite         = "users:write"
+	ScopeWebhooksWrite      = "webhooks:write"
+	ScopeAPIKeysWrite       = "api_keys:write"
+	ScopeCredentialsWrite   = "credentials:write"
+	ScopePartnersAdmin      = "partners:admin"
+)
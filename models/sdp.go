@@ -0,0 +1,114 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+)
+
+var ErrInvalidSdpRequest = errors.New("invalid SDP request")
+
+// SdpSubscriptionRequest: This structure is the body of the SDP activate, wapActivate and deactivate operations.
+type SdpSubscriptionRequest struct {
+	Msisdn      string `json:"msisdn"`
+	OfferCode   string `json:"offerCode"`
+	CpId        string `json:"CpId"`
+	CallBackUrl string `json:"callBackUrl"`
+}
+
+// SdpChargeRequest: This structure is the body of the SDP charge operation.
+type SdpChargeRequest struct {
+	SdpSubscriptionRequest
+	ChargeAmount string `json:"ChargeAmount"`
+}
+
+// SdpAuthRequest: This structure is the body of the SDP token request.
+type SdpAuthRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// SdpAuthResponse: This structure is the SDP token response, expires_in comes as a number or a string of seconds.
+type SdpAuthResponse struct {
+	Token     string      `json:"token"`
+	ExpiresIn interface{} `json:"expires_in"`
+}
+
+// HeAuthResponse: This structure is the HE token response, expires_in comes as a number or a string of seconds.
+type HeAuthResponse struct {
+	AccessToken string      `json:"access_token"`
+	ExpiresIn   interface{} `json:"expires_in"`
+}
+
+// NewSdpSubscriptionRequest builds the SDP body of a subscription request for the content provider cpId.
+func NewSdpSubscriptionRequest(request *HeRequest, cpId, callBackUrl string) SdpSubscriptionRequest {
+	return SdpSubscriptionRequest{
+		Msisdn:      request.Msisdn,
+		OfferCode:   request.OfferCode,
+		CpId:        cpId,
+		CallBackUrl: callBackUrl,
+	}
+}
+
+// NewSdpChargeRequest builds the SDP body of a charge request for the content provider cpId.
+func NewSdpChargeRequest(request *HeRequest, cpId, callBackUrl string) SdpChargeRequest {
+	return SdpChargeRequest{
+		SdpSubscriptionRequest: NewSdpSubscriptionRequest(request, cpId, callBackUrl),
+		ChargeAmount:           request.ChargeAmount,
+	}
+}
+
+func invalidSdpRequest(field, reason string) error {
+	return fmt.Errorf("%w: %s %s", ErrInvalidSdpRequest, field, reason)
+}
+
+// Validate checks the request before it is sent, the SDP refuses requests with missing or malformed fields.
+func (r SdpSubscriptionRequest) Validate() error {
+	if len(r.Msisdn) < 8 || len(r.Msisdn) > 15 {
+		return invalidSdpRequest("msisdn", "must be an E.164 number of 8 to 15 digits")
+	}
+	for _, c := range r.Msisdn {
+		if c < '0' || c > '9' {
+			return invalidSdpRequest("msisdn", "must only contain digits")
+		}
+	}
+	if !ValidOfferCode(r.OfferCode) {
+		return invalidSdpRequest("offerCode", "must be 1 to 64 letters, digits, - or _")
+	}
+	if r.CpId == "" {
+		return invalidSdpRequest("CpId", "is required")
+	}
+	callback, err := url.Parse(r.CallBackUrl)
+	if err != nil || (callback.Scheme != "http" && callback.Scheme != "https") || callback.Host == "" {
+		return invalidSdpRequest("callBackUrl", "must be an absolute http(s) URL")
+	}
+	return nil
+}
+
+// MaxChargeAmount is the largest amount a single charge may ask for.
+const MaxChargeAmount = 100000
+
+// chargeAmountPattern is a plain decimal amount with at most two decimals, as the SDP expects it.
+var chargeAmountPattern = regexp.MustCompile(`^[0-9]+(\.[0-9]{1,2})?$`)
+
+func (r SdpChargeRequest) Validate() error {
+	if err := r.SdpSubscriptionRequest.Validate(); err != nil {
+		return err
+	}
+	if !chargeAmountPattern.MatchString(r.ChargeAmount) {
+		return invalidSdpRequest("ChargeAmount", "must be a decimal amount with at most two decimals")
+	}
+	if amount, err := strconv.ParseFloat(r.ChargeAmount, 64); err != nil || amount <= 0 || amount > MaxChargeAmount {
+		return invalidSdpRequest("ChargeAmount", fmt.Sprintf("must be more than 0 and at most %d", MaxChargeAmount))
+	}
+	return nil
+}
+
+func (r SdpAuthRequest) Validate() error {
+	if r.Username == "" || r.Password == "" {
+		return invalidSdpRequest("username and password", "are required")
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func validSubscriptionRequest() SdpSubscriptionRequest {
+	return SdpSubscriptionRequest{
+		Msisdn:      "254712345678",
+		OfferCode:   "001029900655",
+		CpId:        "1029",
+		CallBackUrl: "https://he.example.com/api/v1/subscriptions/notification",
+	}
+}
+
+func TestSdpSubscriptionRequestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(r *SdpSubscriptionRequest)
+		field  string
+	}{
+		{name: "valid", change: func(r *SdpSubscriptionRequest) {}},
+		{name: "missing msisdn", change: func(r *SdpSubscriptionRequest) { r.Msisdn = "" }, field: "msisdn"},
+		{name: "msisdn too short", change: func(r *SdpSubscriptionRequest) { r.Msisdn = "2547123" }, field: "msisdn"},
+		{name: "msisdn too long", change: func(r *SdpSubscriptionRequest) { r.Msisdn = "2547123456789012" }, field: "msisdn"},
+		{name: "msisdn with plus", change: func(r *SdpSubscriptionRequest) { r.Msisdn = "+254712345678" }, field: "msisdn"},
+		{name: "msisdn with quote", change: func(r *SdpSubscriptionRequest) { r.Msisdn = `2547123456"8` }, field: "msisdn"},
+		{name: "msisdn with sql", change: func(r *SdpSubscriptionRequest) { r.Msisdn = "2547' OR '1'='1" }, field: "msisdn"},
+		{name: "msisdn with json", change: func(r *SdpSubscriptionRequest) { r.Msisdn = `2547","CpId":"1` }, field: "msisdn"},
+		{name: "msisdn with non ascii digits", change: func(r *SdpSubscriptionRequest) { r.Msisdn = "٢٥٤٧١٢٣٤٥٦٧٨" }, field: "msisdn"},
+		{name: "missing offer code", change: func(r *SdpSubscriptionRequest) { r.OfferCode = "" }, field: "offerCode"},
+		{name: "offer code too long", change: func(r *SdpSubscriptionRequest) { r.OfferCode = strings.Repeat("1", 65) }, field: "offerCode"},
+		{name: "offer code with dash and underscore", change: func(r *SdpSubscriptionRequest) { r.OfferCode = "daily_news-01" }},
+		{name: "offer code with quote", change: func(r *SdpSubscriptionRequest) { r.OfferCode = `0010"29` }, field: "offerCode"},
+		{name: "offer code with sql", change: func(r *SdpSubscriptionRequest) { r.OfferCode = "1'; DROP TABLE plans;--" }, field: "offerCode"},
+		{name: "offer code with json", change: func(r *SdpSubscriptionRequest) { r.OfferCode = `1","CpId":"2` }, field: "offerCode"},
+		{name: "offer code with markup", change: func(r *SdpSubscriptionRequest) { r.OfferCode = "<script>" }, field: "offerCode"},
+		{name: "offer code with space", change: func(r *SdpSubscriptionRequest) { r.OfferCode = "001 029" }, field: "offerCode"},
+		{name: "missing content provider", change: func(r *SdpSubscriptionRequest) { r.CpId = "" }, field: "CpId"},
+		{name: "missing callback url", change: func(r *SdpSubscriptionRequest) { r.CallBackUrl = "" }, field: "callBackUrl"},
+		{name: "relative callback url", change: func(r *SdpSubscriptionRequest) { r.CallBackUrl = "/notification" }, field: "callBackUrl"},
+		{name: "callback url of another scheme", change: func(r *SdpSubscriptionRequest) { r.CallBackUrl = "ftp://he.example.com/notification" }, field: "callBackUrl"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := validSubscriptionRequest()
+			test.change(&request)
+			checkSdpValidation(t, request.Validate(), test.field)
+		})
+	}
+}
+
+func TestSdpChargeRequestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(r *SdpChargeRequest)
+		field  string
+	}{
+		{name: "valid", change: func(r *SdpChargeRequest) {}},
+		{name: "decimal amount", change: func(r *SdpChargeRequest) { r.ChargeAmount = "10.50" }},
+		{name: "missing amount", change: func(r *SdpChargeRequest) { r.ChargeAmount = "" }, field: "ChargeAmount"},
+		{name: "zero amount", change: func(r *SdpChargeRequest) { r.ChargeAmount = "0" }, field: "ChargeAmount"},
+		{name: "negative amount", change: func(r *SdpChargeRequest) { r.ChargeAmount = "-10" }, field: "ChargeAmount"},
+		{name: "amount with quote", change: func(r *SdpChargeRequest) { r.ChargeAmount = `10"` }, field: "ChargeAmount"},
+		{name: "largest amount", change: func(r *SdpChargeRequest) { r.ChargeAmount = "100000.00" }},
+		{name: "amount over the maximum", change: func(r *SdpChargeRequest) { r.ChargeAmount = "100000.01" }, field: "ChargeAmount"},
+		{name: "huge amount", change: func(r *SdpChargeRequest) { r.ChargeAmount = "1e300" }, field: "ChargeAmount"},
+		{name: "NaN", change: func(r *SdpChargeRequest) { r.ChargeAmount = "NaN" }, field: "ChargeAmount"},
+		{name: "infinity", change: func(r *SdpChargeRequest) { r.ChargeAmount = "Inf" }, field: "ChargeAmount"},
+		{name: "positive infinity", change: func(r *SdpChargeRequest) { r.ChargeAmount = "+Inf" }, field: "ChargeAmount"},
+		{name: "hex float", change: func(r *SdpChargeRequest) { r.ChargeAmount = "0x1p4" }, field: "ChargeAmount"},
+		{name: "exponent", change: func(r *SdpChargeRequest) { r.ChargeAmount = "1e2" }, field: "ChargeAmount"},
+		{name: "underscores", change: func(r *SdpChargeRequest) { r.ChargeAmount = "1_000" }, field: "ChargeAmount"},
+		{name: "three decimals", change: func(r *SdpChargeRequest) { r.ChargeAmount = "10.505" }, field: "ChargeAmount"},
+		{name: "trailing dot", change: func(r *SdpChargeRequest) { r.ChargeAmount = "10." }, field: "ChargeAmount"},
+		{name: "leading space", change: func(r *SdpChargeRequest) { r.ChargeAmount = " 10" }, field: "ChargeAmount"},
+		{name: "zero with decimals", change: func(r *SdpChargeRequest) { r.ChargeAmount = "0.00" }, field: "ChargeAmount"},
+		{name: "invalid subscription fields", change: func(r *SdpChargeRequest) { r.Msisdn = "0712345678'" }, field: "msisdn"},
+		{name: "offer code with quote", change: func(r *SdpChargeRequest) { r.OfferCode = `'001029` }, field: "offerCode"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := SdpChargeRequest{SdpSubscriptionRequest: validSubscriptionRequest(), ChargeAmount: "10"}
+			test.change(&request)
+			checkSdpValidation(t, request.Validate(), test.field)
+		})
+	}
+}
+
+func TestSdpAuthRequestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		request SdpAuthRequest
+		valid   bool
+	}{
+		{name: "valid", request: SdpAuthRequest{Username: "infinity", Password: "secret"}, valid: true},
+		{name: "missing username", request: SdpAuthRequest{Password: "secret"}},
+		{name: "missing password", request: SdpAuthRequest{Username: "infinity"}},
+		{name: "empty", request: SdpAuthRequest{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.request.Validate()
+			if test.valid {
+				if err != nil {
+					t.Fatalf("Validate() error = %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrInvalidSdpRequest) {
+				t.Fatalf("Validate() error = %v, want %v", err, ErrInvalidSdpRequest)
+			}
+		})
+	}
+}
+
+// checkSdpValidation checks that err is nil when field is empty, or else an ErrInvalidSdpRequest about field.
+func checkSdpValidation(t *testing.T, err error, field string) {
+	t.Helper()
+	if field == "" {
+		if err != nil {
+			t.Fatalf("Validate() error = %v", err)
+		}
+		return
+	}
+	if !errors.Is(err, ErrInvalidSdpRequest) || !strings.Contains(err.Error(), ": "+field+" ") {
+		t.Fatalf("Validate() error = %v, want an %v about %s", err, ErrInvalidSdpRequest, field)
+	}
+}
+
+func TestNewSdpRequests(t *testing.T) {
+	request := &HeRequest{ExternalID: "r1", Msisdn: "254712345678", OfferCode: "001029900655", ChargeAmount: "10"}
+	callBackUrl := "https://he.example.com/api/v1/subscriptions/notification"
+
+	subscription := NewSdpSubscriptionRequest(request, "1029", callBackUrl)
+	want := SdpSubscriptionRequest{Msisdn: "254712345678", OfferCode: "001029900655", CpId: "1029", CallBackUrl: callBackUrl}
+	if subscription != want {
+		t.Errorf("NewSdpSubscriptionRequest() = %+v, want %+v", subscription, want)
+	}
+
+	charge := NewSdpChargeRequest(request, "1029", callBackUrl)
+	if charge.SdpSubscriptionRequest != want || charge.ChargeAmount != "10" {
+		t.Errorf("NewSdpChargeRequest() = %+v", charge)
+	}
+}
+
+// TestSdpRequestsRoundTrip marshals the requests as the gateway sends them and decodes them into the same types
+// as the simulator receives them, checking the field names the SDP expects on the way.
+func TestSdpRequestsRoundTrip(t *testing.T) {
+	subscription := validSubscriptionRequest()
+	charge := SdpChargeRequest{SdpSubscriptionRequest: subscription, ChargeAmount: "10.50"}
+	auth := SdpAuthRequest{Username: "infinity", Password: `p"a\ss`}
+
+	tests := []struct {
+		name    string
+		request interface{}
+		decoded interface{}
+		fields  []string
+	}{
+		{name: "subscription", request: subscription, decoded: &SdpSubscriptionRequest{}, fields: []string{"msisdn", "offerCode", "CpId", "callBackUrl"}},
+		{name: "charge", request: charge, decoded: &SdpChargeRequest{}, fields: []string{"msisdn", "offerCode", "CpId", "callBackUrl", "ChargeAmount"}},
+		{name: "auth", request: auth, decoded: &SdpAuthRequest{}, fields: []string{"username", "password"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			payload, err := json.Marshal(test.request)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			names := map[string]interface{}{}
+			if err := json.Unmarshal(payload, &names); err != nil {
+				t.Fatal(err)
+			}
+			if len(names) != len(test.fields) {
+				t.Errorf("payload %s has %d fields, want %d", payload, len(names), len(test.fields))
+			}
+			for _, field := range test.fields {
+				if _, ok := names[field].(string); !ok {
+					t.Errorf("payload %s has no string field %s", payload, field)
+				}
+			}
+
+			if err := json.Unmarshal(payload, test.decoded); err != nil {
+				t.Fatal(err)
+			}
+			decoded := reflect.ValueOf(test.decoded).Elem().Interface()
+			if !reflect.DeepEqual(decoded, test.request) {
+				t.Errorf("decoded %+v, want %+v", decoded, test.request)
+			}
+			if err := decoded.(interface{ Validate() error }).Validate(); err != nil {
+				t.Errorf("decoded request is not valid: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Subscription states.
+const (
+	SubscriptionPendingActivation   = "pending_activation"
+	SubscriptionActive              = "active"
+	SubscriptionPendingDeactivation = "pending_deactivation"
+	SubscriptionDeactivated         = "deactivated"
+	SubscriptionFailed              = "failed"
+	SubscriptionSuspended           = "suspended"
+)
+
+// Sources of a subscription status change.
+const (
+	SourceUSSD        = "USSD"
+	SourceWeb         = "WEB"
+	SourceSDPCallback = "SDP_CALLBACK"
+)
+
+var (
+	ErrInvalidTransition    = errors.New("invalid subscription status transition")
+	ErrTransitionInProgress = errors.New("a request for this subscription is already in progress")
+)
+
+// subscriptionTransitions lists the states each state may move to. The empty state is a subscription we have not stored yet.
+// Staying in the same state is allowed so that repeated requests and callbacks are still recorded in the history.
+var subscriptionTransitions = map[string][]string{
+	"":                              {SubscriptionPendingActivation, SubscriptionFailed},
+	SubscriptionPendingActivation:   {SubscriptionPendingActivation, SubscriptionActive, SubscriptionFailed, SubscriptionDeactivated},
+	SubscriptionActive:              {SubscriptionActive, SubscriptionPendingDeactivation, SubscriptionDeactivated, SubscriptionSuspended},
+	SubscriptionSuspended:           {SubscriptionSuspended, SubscriptionActive, SubscriptionPendingDeactivation, SubscriptionDeactivated},
+	SubscriptionPendingDeactivation: {SubscriptionPendingDeactivation, SubscriptionDeactivated, SubscriptionActive},
+	SubscriptionDeactivated:         {SubscriptionDeactivated, SubscriptionPendingActivation, SubscriptionFailed},
+	SubscriptionFailed:              {SubscriptionFailed, SubscriptionPendingActivation, SubscriptionActive},
+}
+
+// CanTransition reports whether a subscription in state from may move to state to.
+func CanTransition(from, to string) bool {
+	for _, allowed := range subscriptionTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// SubscriptionEvent: This structure is an append-only record of a subscription status change.
+type SubscriptionEvent struct {
+	ID             uint      `json:"id" gorm:"column:id;primarykey;<-:false;autoIncrement;type:int"`
+	SubscriptionID uint      `json:"subscription" gorm:"column:subscription_id"`
+	FromStatus     string    `json:"from_status" gorm:"column:from_status"`
+	ToStatus       string    `json:"to_status" gorm:"column:to_status"`
+	Source         string    `json:"source" gorm:"column:source"`
+	Payload        string    `json:"payload" gorm:"column:payload"`
+	CreatedAt      time.Time `json:"created_at" gorm:"column:created_at"`
+}
+
+// findForUpdate locks the stored subscription matching sub, by ID when known and otherwise by plan ID and MSISDN.
+// A subscription that does not exist yet is locked through an advisory lock on its plan ID and MSISDN, so that
+// concurrent requests cannot both create it.
+func (sub *Subscription) findForUpdate(tx *gorm.DB) (existing Subscription, found bool, err error) {
+	query := tx.Debug().Table("subscriptions").Clauses(clause.Locking{Strength: "UPDATE"})
+	if sub.ID != 0 {
+		query = query.Where("id = ?", sub.ID)
+	} else {
+		if err = tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", sub.PlanID+"|"+sub.MSISDN).Error; err != nil {
+			return
+		}
+		query = query.Where("plan_id = ? AND msisdn = ?", sub.PlanID, sub.MSISDN)
+	}
+
+	err = query.First(&existing).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return existing, false, nil
+	}
+	return existing, err == nil, err
+}
+
+// Claim moves the subscription to the pending state to before its request is sent to the SDP, so that concurrent
+// requests cannot both be sent: the row lock serialises them and the later ones find the subscription pending,
+// unless that claim is older than stale and its request presumably lost. It returns the state the subscription
+// was claimed from.
+func (sub *Subscription) Claim(tx *gorm.DB, to, source string, stale time.Duration) (from string, err error) {
+	existing, _, err := sub.findForUpdate(tx)
+	if err != nil {
+		return
+	}
+	if existing.Status == to && existing.UpdatedAt.After(time.Now().Add(-stale)) {
+		return existing.Status, fmt.Errorf("%w: subscription is %s", ErrTransitionInProgress, to)
+	}
+	return existing.Status, sub.Transition(tx, to, source, "")
+}
+
+// Revert moves the subscription from the pending state from back to the state to it was claimed from, when its
+// request did not reach the SDP or the SDP refused it. Nothing changes when it left from meanwhile. A subscription
+// the claim created, to being empty, is removed with its history.
+func (sub *Subscription) Revert(tx *gorm.DB, from, to, source, payload string) error {
+	existing, found, err := sub.findForUpdate(tx)
+	if err != nil || !found || existing.Status != from {
+		return err
+	}
+	if to == "" {
+		if err = tx.Debug().Table("subscription_events").Where("subscription_id = ?", existing.ID).Delete(&SubscriptionEvent{}).Error; err != nil {
+			return err
+		}
+		return tx.Debug().Table("subscriptions").Where("id = ?", existing.ID).Delete(&Subscription{}).Error
+	}
+	_, err = sub.apply(tx, existing, found, to, source, payload)
+	return err
+}
+
+// Transition moves the subscription to state to inside tx and appends the change to subscription_events.
+// The subscription is created when it does not exist yet, otherwise the non-zero fields of sub are updated.
+func (sub *Subscription) Transition(tx *gorm.DB, to, source, payload string) error {
+	existing, found, err := sub.findForUpdate(tx)
+	if err != nil {
+		return err
+	}
+	if !CanTransition(existing.Status, to) {
+		return fmt.Errorf("%w: %q -> %q", ErrInvalidTransition, existing.Status, to)
+	}
+	_, err = sub.apply(tx, existing, found, to, source, payload)
+	return err
+}
+
+// Record moves the subscription to a state the SDP reports. The SDP is authoritative, so the change is recorded
+// even when the state machine does not allow it; from is the state it was in and anomaly tells the change was not
+// an allowed transition.
+func (sub *Subscription) Record(tx *gorm.DB, to, source, payload string) (from string, anomaly bool, err error) {
+	existing, found, err := sub.findForUpdate(tx)
+	if err != nil {
+		return
+	}
+	from, err = sub.apply(tx, existing, found, to, source, payload)
+	return from, !CanTransition(existing.Status, to), err
+}
+
+// apply stores the subscription in state to and appends the change from existing to subscription_events.
+func (sub *Subscription) apply(tx *gorm.DB, existing Subscription, found bool, to, source, payload string) (from string, err error) {
+	sub.Status = to
+	if found {
+		sub.ID = existing.ID
+		if err = tx.Debug().Table("subscriptions").Where("id = ?", existing.ID).Updates(sub).Error; err != nil {
+			return
+		}
+	} else if err = tx.Debug().Table("subscriptions").Create(sub).Error; err != nil {
+		return
+	}
+
+	event := SubscriptionEvent{
+		SubscriptionID: sub.ID,
+		FromStatus:     existing.Status,
+		ToStatus:       to,
+		Source:         source,
+		Payload:        payload,
+	}
+	return existing.Status, tx.Debug().Table("subscription_events").Create(&event).Error
+}
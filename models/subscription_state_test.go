@@ -0,0 +1,38 @@
+package models
+
+import "testing"
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		from, to string
+		want     bool
+	}{
+		{from: "", to: SubscriptionPendingActivation, want: true},
+		{from: "", to: SubscriptionFailed, want: true},
+		{from: "", to: SubscriptionActive, want: false},
+		{from: SubscriptionPendingActivation, to: SubscriptionActive, want: true},
+		{from: SubscriptionPendingActivation, to: SubscriptionPendingActivation, want: true},
+		{from: SubscriptionPendingActivation, to: SubscriptionSuspended, want: false},
+		{from: SubscriptionActive, to: SubscriptionPendingDeactivation, want: true},
+		{from: SubscriptionActive, to: SubscriptionSuspended, want: true},
+		{from: SubscriptionActive, to: SubscriptionPendingActivation, want: false},
+		{from: SubscriptionActive, to: SubscriptionFailed, want: false},
+		{from: SubscriptionSuspended, to: SubscriptionActive, want: true},
+		{from: SubscriptionPendingDeactivation, to: SubscriptionDeactivated, want: true},
+		{from: SubscriptionPendingDeactivation, to: SubscriptionActive, want: true},
+		{from: SubscriptionDeactivated, to: SubscriptionPendingActivation, want: true},
+		{from: SubscriptionDeactivated, to: SubscriptionActive, want: false},
+		{from: SubscriptionFailed, to: SubscriptionActive, want: true},
+		{from: SubscriptionFailed, to: SubscriptionDeactivated, want: false},
+		{from: "A", to: SubscriptionActive, want: false},
+		{from: SubscriptionActive, to: "", want: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.from+" to "+test.to, func(t *testing.T) {
+			if got := CanTransition(test.from, test.to); got != test.want {
+				t.Errorf("CanTransition(%q, %q) = %v, want %v", test.from, test.to, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package models
+
+import "time"
+
+// TokenPair: This structure is the response of the login and refresh endpoints.
+type TokenPair struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+// RefreshRequest: This structure is the body of the refresh and revoke endpoints.
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// RevokeRequest: This structure is the body of the logout endpoint, the refresh token is optional.
+type RevokeRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// RefreshToken: This structure is a stored refresh token. Only the hash of the token is kept.
+// Every refresh rotates the token, all tokens descending from the same login share a FamilyID.
+type RefreshToken struct {
+	ID        uint       `json:"id" gorm:"column:id;primarykey;<-:false;autoIncrement;type:int"`
+	TokenHash string     `json:"-" gorm:"column:token_hash"`
+	FamilyID  string     `json:"family_id" gorm:"column:family_id"`
+	PartnerID string     `json:"partner_id" gorm:"column:partner_id"`
+	UserID    string     `json:"user_id" gorm:"column:user_id"`
+	Role      string     `json:"role" gorm:"column:role"`
+	Scope     string     `json:"scope" gorm:"column:scope"`
+	ExpiresAt time.Time  `json:"expires_at" gorm:"column:expires_at"`
+	RevokedAt *time.Time `json:"revoked_at" gorm:"column:revoked_at"`
+	CreatedAt time.Time  `json:"created_at" gorm:"column:created_at"`
+}
+
+// RevokedToken: This structure is an access token (by its jti claim) that must no longer be accepted.
+// Rows can be purged once ExpiresAt has passed since the token is rejected as expired from then on.
+type RevokedToken struct {
+	JTI       string    `json:"jti" gorm:"column:jti;primarykey"`
+	ExpiresAt time.Time `json:"expires_at" gorm:"column:expires_at"`
+	CreatedAt time.Time `json:"created_at" gorm:"column:created_at"`
+}
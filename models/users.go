@@ -0,0 +1,38 @@
+package models
+
+import "time"
+
+// UserLogin: This structure is the body of the user login. Users belonging to several partners pick one with Partner,
+// otherwise their oldest active membership is used.
+type UserLogin struct {
+	Username  string `json:"username" binding:"required"`
+	Password  string `json:"password" binding:"required"`
+	PartnerID uint   `json:"partner" binding:"-"`
+	Scope     string `json:"scope"`
+}
+
+// UserInvite: This structure is the body of the endpoint inviting a user to the caller's partner.
+type UserInvite struct {
+	Email     string `json:"email" binding:"required,email"`
+	FirstName string `json:"firstname"`
+	LastName  string `json:"lastname"`
+	Role      string `json:"role" binding:"required,oneof=partner read_only"`
+}
+
+// InviteAcceptance: This structure is the body of the endpoint an invited user sets their password with.
+type InviteAcceptance struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required,min=8"`
+}
+
+// PartnerMember: This structure is a user as seen from one of the partners they belong to.
+type PartnerMember struct {
+	UserID    uint      `json:"user_id" gorm:"column:user_id"`
+	Email     string    `json:"email" gorm:"column:email"`
+	FirstName string    `json:"firstname" gorm:"column:firstname"`
+	LastName  string    `json:"lastname" gorm:"column:lastname"`
+	Role      string    `json:"role" gorm:"column:role"`
+	Active    bool      `json:"active" gorm:"column:active"`
+	Invited   bool      `json:"invited" gorm:"column:invited"`
+	CreatedAt time.Time `json:"created_at" gorm:"column:created_at"`
+}
@@ -0,0 +1,120 @@
+// Package msisdn validates subscriber numbers and puts them in the one form we store, query and send to the SDP:
+// the E.164 number as digits without the leading "+", e.g. 254712345678.
+//
+// Numbers are accepted in international format (+254712345678, 00254712345678, 254712345678) or in the national
+// format of the default country (0712345678, 712345678). Spaces, dashes, dots and brackets are ignored.
+package msisdn
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrEmpty            = errors.New("msisdn is empty")
+	ErrInvalidCharacter = errors.New("msisdn may only contain digits and a leading +")
+	ErrUnknownCountry   = errors.New("msisdn country is not supported")
+	ErrInvalidLength    = errors.New("msisdn has the wrong number of digits")
+	ErrNotMobile        = errors.New("msisdn is not in a mobile range")
+)
+
+// Country is the numbering plan of a country: its calling code, the length of its national significant numbers
+// (the digits after the calling code) and the leading digits of its mobile ranges.
+type Country struct {
+	Code           string
+	CallingCode    string
+	Length         int
+	MobilePrefixes []string
+}
+
+// countries are the numbering plans we know, by ISO 3166 alpha-2 code.
+var countries = map[string]Country{
+	"KE": {Code: "KE", CallingCode: "254", Length: 9, MobilePrefixes: []string{"7", "10", "11"}},
+	"UG": {Code: "UG", CallingCode: "256", Length: 9, MobilePrefixes: []string{"7"}},
+	"TZ": {Code: "TZ", CallingCode: "255", Length: 9, MobilePrefixes: []string{"6", "7"}},
+	"RW": {Code: "RW", CallingCode: "250", Length: 9, MobilePrefixes: []string{"72", "73", "78", "79"}},
+}
+
+// CountryByCode returns the numbering plan of the country.
+func CountryByCode(code string) (Country, error) {
+	country, ok := countries[strings.ToUpper(code)]
+	if !ok {
+		return Country{}, fmt.Errorf("%w: %s", ErrUnknownCountry, code)
+	}
+	return country, nil
+}
+
+// Normalize validates number and returns it in E.164 digits. National numbers are read as numbers of the first of
+// the countries, international ones must belong to one of them.
+func Normalize(number string, countryCodes ...string) (string, error) {
+	plans := make([]Country, 0, len(countryCodes))
+	for _, code := range countryCodes {
+		country, err := CountryByCode(code)
+		if err != nil {
+			return "", err
+		}
+		plans = append(plans, country)
+	}
+	if len(plans) == 0 {
+		return "", fmt.Errorf("%w: no country configured", ErrUnknownCountry)
+	}
+
+	digits := strings.NewReplacer(" ", "", "-", "", ".", "", "(", "", ")", "").Replace(strings.TrimSpace(number))
+	if digits == "" {
+		return "", ErrEmpty
+	}
+	international := false
+	switch {
+	case strings.HasPrefix(digits, "+"):
+		digits, international = digits[1:], true
+	case strings.HasPrefix(digits, "00"):
+		digits, international = digits[2:], true
+	}
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return "", fmt.Errorf("%w: %q", ErrInvalidCharacter, number)
+		}
+	}
+
+	country, national := plans[0], ""
+	switch {
+	case international:
+		found := false
+		for _, candidate := range plans {
+			if strings.HasPrefix(digits, candidate.CallingCode) {
+				country, national, found = candidate, digits[len(candidate.CallingCode):], true
+				break
+			}
+		}
+		if !found {
+			return "", fmt.Errorf("%w: %q", ErrUnknownCountry, number)
+		}
+	case strings.HasPrefix(digits, "0"):
+		national = digits[1:]
+	case len(digits) == country.Length:
+		national = digits
+	default:
+		// international format without the "+"
+		found := false
+		for _, candidate := range plans {
+			if strings.HasPrefix(digits, candidate.CallingCode) && len(digits) == len(candidate.CallingCode)+candidate.Length {
+				country, national, found = candidate, digits[len(candidate.CallingCode):], true
+				break
+			}
+		}
+		if !found {
+			return "", fmt.Errorf("%w: %q", ErrInvalidLength, number)
+		}
+	}
+
+	if len(national) != country.Length {
+		return "", fmt.Errorf("%w: %q, %s numbers have %d digits after +%s", ErrInvalidLength, number, country.Code, country.Length, country.CallingCode)
+	}
+	for _, prefix := range country.MobilePrefixes {
+		if strings.HasPrefix(national, prefix) {
+			return country.CallingCode + national, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %q is not a %s mobile number", ErrNotMobile, number, country.Code)
+}
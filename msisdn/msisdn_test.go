@@ -0,0 +1,97 @@
+package msisdn
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name      string
+		number    string
+		countries []string
+		want      string
+		err       error
+	}{
+		{name: "international with plus", number: "+254712345678", countries: []string{"KE"}, want: "254712345678"},
+		{name: "international with 00", number: "00254712345678", countries: []string{"KE"}, want: "254712345678"},
+		{name: "international without plus", number: "254712345678", countries: []string{"KE"}, want: "254712345678"},
+		{name: "national with trunk prefix", number: "0712345678", countries: []string{"KE"}, want: "254712345678"},
+		{name: "national without trunk prefix", number: "712345678", countries: []string{"KE"}, want: "254712345678"},
+		{name: "separators are ignored", number: " +254 (712) 345-67.8 ", countries: []string{"KE"}, want: "254712345678"},
+		{name: "new kenyan range", number: "0110123456", countries: []string{"KE"}, want: "254110123456"},
+		{name: "national read in the first country", number: "0712345678", countries: []string{"UG", "KE"}, want: "256712345678"},
+		{name: "international of a later country", number: "+255612345678", countries: []string{"KE", "TZ"}, want: "255612345678"},
+		{name: "international without plus of a later country", number: "250781234567", countries: []string{"KE", "RW"}, want: "250781234567"},
+		{name: "empty", number: "  ", countries: []string{"KE"}, err: ErrEmpty},
+		{name: "letters", number: "07123abc78", countries: []string{"KE"}, err: ErrInvalidCharacter},
+		{name: "quote", number: "0712345678'", countries: []string{"KE"}, err: ErrInvalidCharacter},
+		{name: "plus inside", number: "254+712345678", countries: []string{"KE"}, err: ErrInvalidCharacter},
+		{name: "country not configured", number: "+256712345678", countries: []string{"KE"}, err: ErrUnknownCountry},
+		{name: "unknown country code", number: "0712345678", countries: []string{"XX"}, err: ErrUnknownCountry},
+		{name: "no country", number: "0712345678", err: ErrUnknownCountry},
+		{name: "national too short", number: "071234567", countries: []string{"KE"}, err: ErrInvalidLength},
+		{name: "national too long", number: "07123456789", countries: []string{"KE"}, err: ErrInvalidLength},
+		{name: "international too long", number: "+2547123456789", countries: []string{"KE"}, err: ErrInvalidLength},
+		{name: "international without plus of the wrong length", number: "25471234567", countries: []string{"KE"}, err: ErrInvalidLength},
+		{name: "landline", number: "0202345678", countries: []string{"KE"}, err: ErrNotMobile},
+		{name: "outside the rwandan mobile ranges", number: "+250712345678", countries: []string{"RW"}, err: ErrNotMobile},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := Normalize(test.number, test.countries...)
+			if test.err != nil {
+				if !errors.Is(err, test.err) {
+					t.Fatalf("Normalize(%q) error = %v, want %v", test.number, err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Normalize(%q) error = %v", test.number, err)
+			}
+			if got != test.want {
+				t.Errorf("Normalize(%q) = %q, want %q", test.number, got, test.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeIsIdempotent(t *testing.T) {
+	for _, number := range []string{"0712345678", "+255612345678", "256712345678"} {
+		once, err := Normalize(number, "KE", "UG", "TZ")
+		if err != nil {
+			t.Fatalf("Normalize(%q) error = %v", number, err)
+		}
+		twice, err := Normalize(once, "KE", "UG", "TZ")
+		if err != nil || twice != once {
+			t.Errorf("Normalize(%q) = %q, %v, want %q", once, twice, err, once)
+		}
+	}
+}
+
+func TestCountryByCode(t *testing.T) {
+	tests := []struct {
+		code        string
+		callingCode string
+		err         error
+	}{
+		{code: "KE", callingCode: "254"},
+		{code: "ug", callingCode: "256"},
+		{code: "TZ", callingCode: "255"},
+		{code: "RW", callingCode: "250"},
+		{code: "", err: ErrUnknownCountry},
+		{code: "XX", err: ErrUnknownCountry},
+	}
+
+	for _, test := range tests {
+		country, err := CountryByCode(test.code)
+		if !errors.Is(err, test.err) {
+			t.Errorf("CountryByCode(%q) error = %v, want %v", test.code, err, test.err)
+			continue
+		}
+		if country.CallingCode != test.callingCode {
+			t.Errorf("CountryByCode(%q).CallingCode = %q, want %q", test.code, country.CallingCode, test.callingCode)
+		}
+	}
+}
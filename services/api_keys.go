@@ -0,0 +1,140 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/apeli23/infinity/database"
+	"github.com/apeli23/infinity/models"
+)
+
+// apiKeyPrefix marks our API keys so they are easy to spot in logs and secret scanners.
+const apiKeyPrefix = "ik_"
+
+var (
+	ErrInvalidAPIKey  = errors.New("invalid or revoked api key")
+	ErrAPIKeyNotFound = errors.New("api key not found")
+)
+
+// apiKeyDefaultScopes are the scopes of keys created without a scope: the SDP calls integrations make.
+// Keys managing credentials (api_keys:write, credentials:write) must be asked for explicitly.
+var apiKeyDefaultScopes = []string{
+	models.ScopeSubscriptionsWrite,
+	models.ScopeChargeWrite,
+}
+
+// CreateAPIKey issues an API key for the partner. The key is only returned here, we keep its hash.
+// Keys look like ik_<prefix>.<secret> where the prefix is stored in clear to identify the key.
+// A key never holds more scopes than callerScope, the scope of the token creating it, and is revoked
+// when accountId, the user creating it, is deactivated.
+func CreateAPIKey(partnerId, accountId, callerScope string, request models.APIKeyRequest) (key models.APIKey, secret string, err error) {
+	partner, err := strconv.ParseUint(partnerId, 10, 64)
+	if err != nil {
+		return
+	}
+	var createdBy *uint
+	if accountId != "" {
+		user, err := strconv.ParseUint(accountId, 10, 64)
+		if err != nil {
+			return key, "", err
+		}
+		id := uint(user)
+		createdBy = &id
+	}
+	if request.ExpiresAt != nil && request.ExpiresAt.Before(time.Now()) {
+		err = fmt.Errorf("expires_at is in the past")
+		return
+	}
+
+	defaults := []string{}
+	for _, candidate := range apiKeyDefaultScopes {
+		if HasScopes(callerScope, candidate) {
+			defaults = append(defaults, candidate)
+		}
+	}
+	scope := strings.Join(defaults, " ")
+	if strings.TrimSpace(request.Scope) != "" {
+		if scope, err = GrantScopes(models.RolePartner, request.Scope); err != nil {
+			return
+		}
+		if !HasScopes(callerScope, strings.Fields(scope)...) {
+			err = fmt.Errorf("api key cannot hold scopes the caller does not have")
+			return
+		}
+	}
+	if scope == "" {
+		err = fmt.Errorf("scope is required, the caller cannot grant %s", strings.Join(apiKeyDefaultScopes, " "))
+		return
+	}
+
+	prefix, err := GenerateSecret(4)
+	if err != nil {
+		return
+	}
+	random, err := GenerateSecret(32)
+	if err != nil {
+		return
+	}
+	secret = fmt.Sprintf("%s%s.%s", apiKeyPrefix, prefix, random)
+
+	key = models.APIKey{
+		PartnerID:       uint(partner),
+		Name:            request.Name,
+		Prefix:          apiKeyPrefix + prefix,
+		KeyHash:         HashToken(secret),
+		Scope:           scope,
+		CreatedByUserID: createdBy,
+		ExpiresAt:       request.ExpiresAt,
+		CreatedAt:       time.Now(),
+	}
+	err = database.Db.Debug().Table("partner_api_keys").Create(&key).Error
+	return
+}
+
+// ListAPIKeys returns every API key of the partner, revoked ones included.
+func ListAPIKeys(partnerId string) (keys []models.APIKey, err error) {
+	keys = []models.APIKey{}
+	err = database.Db.Debug().Table("partner_api_keys").Where("partner_id = ?", partnerId).Order("id").Find(&keys).Error
+	return
+}
+
+// RevokeAPIKey stops the key from being accepted, effective immediately.
+func RevokeAPIKey(partnerId, keyId string) error {
+	result := database.Db.Debug().Table("partner_api_keys").
+		Where("id = ? AND partner_id = ? AND revoked_at IS NULL", keyId, partnerId).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// AuthenticateAPIKey returns the key matching the X-Api-Key header value when it is neither revoked nor expired.
+// Last use is recorded at most once a minute per key to keep busy integrations from writing on every request.
+func AuthenticateAPIKey(secret string) (key models.APIKey, err error) {
+	if !strings.HasPrefix(secret, apiKeyPrefix) {
+		return key, ErrInvalidAPIKey
+	}
+	if err = database.Db.Table("partner_api_keys").Where("key_hash = ?", HashToken(secret)).First(&key).Error; err != nil {
+		return key, ErrInvalidAPIKey
+	}
+	now := time.Now()
+	if key.RevokedAt != nil || (key.ExpiresAt != nil && key.ExpiresAt.Before(now)) {
+		return key, ErrInvalidAPIKey
+	}
+
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > time.Minute {
+		if err := database.Db.Table("partner_api_keys").Where("id = ?", key.ID).Update("last_used_at", now).Error; err != nil {
+			logrus.Error(err)
+		}
+	}
+	return key, nil
+}
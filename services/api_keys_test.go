@@ -0,0 +1,52 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apeli23/infinity/models"
+)
+
+// TestCreateAPIKeyRefused covers the requests refused before a key is generated and stored.
+func TestCreateAPIKeyRefused(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	partnerScope := RoleScopes(models.RolePartner)
+	readScope := RoleScopes(models.RoleReadOnly)
+	tests := []struct {
+		name        string
+		partnerId   string
+		accountId   string
+		callerScope string
+		request     models.APIKeyRequest
+		wantErr     string
+	}{
+		{name: "no partner", partnerId: "", callerScope: partnerScope, request: models.APIKeyRequest{Name: "ci"}, wantErr: "invalid syntax"},
+		{name: "invalid account", partnerId: "1", accountId: "ops", callerScope: partnerScope, request: models.APIKeyRequest{Name: "ci"}, wantErr: "invalid syntax"},
+		{name: "expired", partnerId: "1", callerScope: partnerScope, request: models.APIKeyRequest{Name: "ci", ExpiresAt: &past}, wantErr: "expires_at is in the past"},
+		{name: "scope of another role", partnerId: "1", callerScope: partnerScope, request: models.APIKeyRequest{Name: "ci", Scope: models.ScopePartnersAdmin}, wantErr: "not available to role"},
+		{name: "scope the caller lacks", partnerId: "1", callerScope: models.ScopeChargeWrite, request: models.APIKeyRequest{Name: "ci", Scope: models.ScopeAPIKeysWrite}, wantErr: "scopes the caller does not have"},
+		{name: "caller without default scopes", partnerId: "1", callerScope: readScope, request: models.APIKeyRequest{Name: "ci"}, wantErr: "scope is required"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, secret, err := CreateAPIKey(test.partnerId, test.accountId, test.callerScope, test.request)
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Fatalf("CreateAPIKey() error = %v, want %q", err, test.wantErr)
+			}
+			if secret != "" {
+				t.Errorf("CreateAPIKey() returned a secret with its error")
+			}
+		})
+	}
+}
+
+func TestAuthenticateAPIKeyPrefix(t *testing.T) {
+	for _, secret := range []string{"", "Bearer abc", "sk_1234.abcd"} {
+		if _, err := AuthenticateAPIKey(secret); !errors.Is(err, ErrInvalidAPIKey) {
+			t.Errorf("AuthenticateAPIKey(%q) error = %v, want %v", secret, err, ErrInvalidAPIKey)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/apeli23/infinity/database"
+	"github.com/apeli23/infinity/models"
+	"github.com/apeli23/infinity/utils"
+	"github.com/apeli23/infinity/webhook"
+)
+
+// EnqueueCallback queues a partner notification inside tx. Nothing is queued when the partner did not give us a callback URL.
+func EnqueueCallback(tx *gorm.DB, partnerId uint, url string, payload []byte) error {
+	if url == "" {
+		logrus.Warn("no callback url, partner notification not queued")
+		return nil
+	}
+	entry := models.CallbackOutbox{
+		PartnerID:   partnerId,
+		Url:         url,
+		Payload:     string(payload),
+		MaxAttempts: utils.GetEnvInt("CALLBACK_MAX_ATTEMPTS", 10),
+	}
+	return entry.Enqueue(tx)
+}
+
+// callbackBackoff returns how long to wait before the next delivery attempt: it doubles from
+// CALLBACK_RETRY_BASE on every failed attempt and is capped at CALLBACK_RETRY_MAX.
+func callbackBackoff(attempts int) time.Duration {
+	base := utils.GetEnvDuration("CALLBACK_RETRY_BASE", 30*time.Second)
+	max := utils.GetEnvDuration("CALLBACK_RETRY_MAX", 6*time.Hour)
+
+	delay := base
+	for i := 1; i < attempts && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
+
+// callbackLease is how long a claimed callback is kept from other dispatchers: CALLBACK_LEASE (2m), raised above
+// the longest a single delivery can take so that a lease never runs out while its callback is being sent.
+func callbackLease() time.Duration {
+	lease := utils.GetEnvDuration("CALLBACK_LEASE", 2*time.Minute)
+	longest := utils.GetEnvDuration("HTTP_REQUEST_TIMEOUT", 60*time.Second) + utils.GetEnvDuration("HTTP_QUEUE_TIMEOUT", 2*time.Second)
+	if lease < 2*longest {
+		lease = 2 * longest
+	}
+	return lease
+}
+
+// leaseUntil returns the end of a lease taken now, at the precision postgres stores it so that it can be compared.
+func leaseUntil() time.Time {
+	return time.Now().Add(callbackLease()).Truncate(time.Microsecond)
+}
+
+// claimDueCallbacks locks a batch of due callbacks and pushes their next attempt forward by a lease,
+// so that other instances running the dispatcher skip them while they are being delivered.
+func claimDueCallbacks() (due []models.CallbackOutbox, err error) {
+	now := time.Now()
+	until := leaseUntil()
+
+	err = database.Db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Table("callback_outbox").
+			Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND next_attempt_at <= ?", models.CallbackPending, now).
+			Order("next_attempt_at").
+			Limit(utils.GetEnvInt("CALLBACK_BATCH_SIZE", 50)).
+			Find(&due).Error; err != nil {
+			return err
+		}
+		if len(due) == 0 {
+			return nil
+		}
+
+		ids := make([]uint, 0, len(due))
+		for i := range due {
+			ids = append(ids, due[i].ID)
+			due[i].NextAttemptAt = until
+		}
+		return tx.Table("callback_outbox").Where("id IN ?", ids).Update("next_attempt_at", until).Error
+	})
+	return
+}
+
+// renewCallbackLease takes a fresh lease on the entry right before it is sent, since the batch lease may have run
+// out while the callbacks before it were delivered. It fails when another dispatcher reclaimed the entry meanwhile.
+func renewCallbackLease(entry *models.CallbackOutbox) bool {
+	until := leaseUntil()
+	result := database.Db.Table("callback_outbox").
+		Where("id = ? AND status = ? AND next_attempt_at = ?", entry.ID, models.CallbackPending, entry.NextAttemptAt).
+		Update("next_attempt_at", until)
+	if result.Error != nil {
+		logrus.Error(result.Error)
+		return false
+	}
+	if result.RowsAffected == 0 {
+		logrus.Warnf("callback %d was reclaimed by another dispatcher, skipped", entry.ID)
+		return false
+	}
+	entry.NextAttemptAt = until
+	return true
+}
+
+// callbackHeaders builds the headers of a delivery attempt, signing the payload with the partner's webhook secrets.
+// The signature is computed on every attempt so that retries pick up rotated secrets.
+func callbackHeaders(entry models.CallbackOutbox) map[string][]string {
+	headers := map[string][]string{
+		"Content-Type": {"application/json"},
+	}
+
+	secrets, err := PartnerWebhookSecrets(entry.PartnerID)
+	if err != nil {
+		logrus.Error(err)
+	}
+	if len(secrets) == 0 {
+		logrus.Warnf("partner %d has no webhook secret, callback %d sent unsigned", entry.PartnerID, entry.ID)
+		return headers
+	}
+
+	timestamp := time.Now().Unix()
+	headers[webhook.TimestampHeader] = []string{fmt.Sprintf("%d", timestamp)}
+	headers[webhook.SignatureHeader] = []string{webhook.Header(secrets, timestamp, []byte(entry.Payload))}
+	return headers
+}
+
+// deliverCallback makes one delivery attempt, records it and moves the entry to its next state.
+func deliverCallback(entry models.CallbackOutbox) {
+	if !renewCallbackLease(&entry) {
+		return
+	}
+	start := time.Now()
+	response, err := utils.Request(entry.Payload, callbackHeaders(entry), entry.Url, "POST")
+
+	entry.Attempts++
+	attempt := models.CallbackAttempt{
+		OutboxID: entry.ID,
+		Attempt:  entry.Attempts,
+		Response: response,
+		Duration: time.Since(start).Milliseconds(),
+	}
+
+	update := map[string]interface{}{
+		"attempts":   entry.Attempts,
+		"updated_at": time.Now(),
+	}
+	switch {
+	case err == nil:
+		update["status"] = models.CallbackDelivered
+		update["last_error"] = ""
+	case entry.Attempts >= entry.MaxAttempts:
+		attempt.Error = err.Error()
+		update["status"] = models.CallbackDeadLetter
+		update["last_error"] = err.Error()
+		logrus.Errorf("CALLBACK DEAD LETTER | ID : %d | URL : %s | ATTEMPTS : %d | ERROR : %v", entry.ID, entry.Url, entry.Attempts, err)
+	default:
+		attempt.Error = err.Error()
+		update["last_error"] = err.Error()
+		update["next_attempt_at"] = time.Now().Add(callbackBackoff(entry.Attempts))
+	}
+
+	err = database.Db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Debug().Table("callback_attempts").Create(&attempt).Error; err != nil {
+			return err
+		}
+		// only the dispatcher holding the lease records the outcome
+		return tx.Debug().Table("callback_outbox").Where("id = ? AND next_attempt_at = ?", entry.ID, entry.NextAttemptAt).Updates(update).Error
+	})
+	if err != nil {
+		logrus.Error(err)
+	}
+}
+
+// StartCallbackDispatcher polls the outbox for due callbacks and delivers them until the process exits.
+func StartCallbackDispatcher() {
+	go dispatchCallbacks()
+}
+
+func dispatchCallbacks() {
+	for {
+		due, err := claimDueCallbacks()
+		if err != nil {
+			logrus.Error(err)
+		}
+		for _, entry := range due {
+			deliverCallback(entry)
+		}
+		if len(due) == 0 {
+			<-time.After(utils.GetEnvDuration("CALLBACK_POLL_INTERVAL", 5*time.Second))
+		}
+	}
+}
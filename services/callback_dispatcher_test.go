@@ -0,0 +1,54 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCallbackBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{attempts: 0, want: 30 * time.Second},
+		{attempts: 1, want: 30 * time.Second},
+		{attempts: 2, want: time.Minute},
+		{attempts: 5, want: 8 * time.Minute},
+		{attempts: 10, want: 256 * time.Minute},
+		{attempts: 11, want: 6 * time.Hour},
+		{attempts: 100, want: 6 * time.Hour},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%d attempts", test.attempts), func(t *testing.T) {
+			if got := callbackBackoff(test.attempts); got != test.want {
+				t.Errorf("callbackBackoff(%d) = %v, want %v", test.attempts, got, test.want)
+			}
+		})
+	}
+}
+
+func TestCallbackLease(t *testing.T) {
+	tests := []struct {
+		name           string
+		lease          string
+		requestTimeout string
+		want           time.Duration
+	}{
+		{name: "defaults", want: 2*time.Minute + 4*time.Second},
+		{name: "configured", lease: "10m", want: 10 * time.Minute},
+		{name: "raised above a delivery", lease: "10s", requestTimeout: "5s", want: 14 * time.Second},
+		{name: "longer than a delivery", lease: "1m", requestTimeout: "5s", want: time.Minute},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("CALLBACK_LEASE", test.lease)
+			t.Setenv("HTTP_REQUEST_TIMEOUT", test.requestTimeout)
+			if got := callbackLease(); got != test.want {
+				t.Errorf("callbackLease() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
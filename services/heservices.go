@@ -1,133 +1,67 @@
 package services
 
 import (
-	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"os"
 	"time"
 
 	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
 
 	"github.com/apeli23/infinity/database"
 	"github.com/apeli23/infinity/models"
 	"github.com/apeli23/infinity/utils"
 )
 
-//this function performs a login and returns an access token. 
-func HeLoginToken() (token string, err error) {
-
-	//check if the token is already cached in memory, and if so, it returns the cached token
-	if val, ok := utils.CacheInstance.Get("HE_TOKEN"); ok {
-		return val.(string), nil
-	}
-	// Otherwise, it makes an HTTP request to the authentication endpoint with the provided credentials...
-	auth := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", os.Getenv("HE_USERNAME"), os.Getenv("HE_PASSWORD"))))
-	//...and parses the response JSON to extract the access token. 
-	res, err := utils.Request("", map[string][]string{
-		"Content-Type":  {"application/x-www-form-urlencoded"},
-		"Authorization": {fmt.Sprintf("Basic %s", auth)},
-	}, os.Getenv("HE_AUTH_URL"), "POST")
-
-	if err != nil {
-		logrus.Error(err)
-		return
-	}
-
-	response := map[string]interface{}{}
-
-	if err = json.Unmarshal([]byte(res), &response); err != nil {
-		logrus.Error(err)
-		return
-	}
-	// cache the access token in memory with a TTL of 50 minutes and returns it
-	token = response["access_token"].(string)
-	utils.CacheInstance.Set("HE_TOKEN", response["access_token"], 50*time.Minute)
-	return
-
+//this function returns the operator's HE access token, logging in when there is no valid one. See TokenSource.
+func HeLoginToken(operator models.Operator) (token string, err error) {
+	return tokensFor(operator).he.Token()
 }
 
 //function to retrieve a token for authenticating against a third-party service
-//retruns a token
-func GetSdpToken() (token string, err error) {
-
-	//if the token is already chached return cached token
-	if val, ok := utils.CacheInstance.Get("SDP_TOKEN"); ok {
-		return val.(string), nil
-	}
-	//If the token is not cached, construct a payload that includes the SDP username and password.
-	payload := fmt.Sprintf(`{"username":"%s","password":"%s"}`, os.Getenv("SDP_USERNAME"), os.Getenv("SDP_PASSWORD"))
-
-	//send HTTP POST request to the SDP authentication URL, including the payload headers
-	//response in JSON
-
-	res, err := utils.Request(payload, map[string][]string{
-		"Content-Type":     {`application/json`},
-		"Accept":           {`application/json`},
-		"X-Requested-With": {"XMLHttpRequest"},
-	}, os.Getenv("SDP_AUTH_URL"), "POST")
-
-	if err != nil {
-		logrus.Error(err)
-		return
-	}
-
-	response := map[string]string{}
-
-	if err = json.Unmarshal([]byte(res), &response); err != nil {
-		logrus.Error(err)
-		return
-	}
-// the token from the JSON response and caches it for future use.
-	token = response["token"]
-
-	utils.CacheInstance.Set("SDP_TOKEN", token, 50*time.Minute)
-	return
-
+//retruns the operator's SDP token, see TokenSource.
+func GetSdpToken(operator models.Operator) (token string, err error) {
+	return tokensFor(operator).sdp.Token()
 }
 
 //Below function sends activation requests
 func SendActivation(activation *models.HeRequest, channel string) (heResponse models.HeResponse, err error) {
-	//construct a url based on `HE_BASE_URL`
-	url := fmt.Sprintf("%s/api/v1/activate", os.Getenv("HE_BASE_URL"))
-	//construct a payload in JSON format using data from the activation parameter and other environment variables.
-	payoad := fmt.Sprintf(`{
-		"msisdn": "%s",
-		"offerCode": "%s",
-		"CpId": "%s",
-		"callBackUrl": "%s"
-	}`, activation.Msisdn, activation.OfferCode, os.Getenv("CPID"), os.Getenv("ACT_DEACT_NOTIFICATION"))
-
-	// the `BuildHeaders` function builds a map of HTTP headers that will be included in the API request
-	headers, err := BuildHeaders(activation.ExternalID)
-
+	// route the request to the operator of the plan and subscriber, and make sure we can authenticate with its SDP
+	gateway, err := requestGateway(activation)
 	if err != nil {
 		logrus.Error(err)
 		return
 	}
+	//claim the subscription before calling the SDP, refusing it when its current state does not allow an activation
+	previous, err := claimSubscription(activation, channel, models.SubscriptionPendingActivation)
+	if err != nil {
+		return
+	}
 
-	response, err := utils.Request(payoad, headers, url, "POST")
+	response, err := gateway.Activate(activation)
 	if err != nil {
 		logrus.Error(err)
-		err = errors.New(response)
+		err = sdpRequestError(err, response)
+		activationFailed(activation, channel, previous, response, err)
 		return
 	}
-	heResponse, err = HeResponseProcessing(activation, response, channel)
+	heResponse, err = HeResponseProcessing(activation, response, channel, models.SubscriptionPendingActivation)
 	return
 
 }
 
 // function builds and returns a map of HTTP headers that need to be included in API requests
 //It takes requesId as an argument, which is used to set X-Correlation-Conversation-ID and X-MessageID headers.
-func BuildHeaders(requesId string) (map[string][]string, error) {
+//The operator's header profile is applied on top of the defaults.
+func BuildHeaders(operator models.Operator, requesId string) (map[string][]string, error) {
 	//get required tokens
 	//If HeLoginToken or GetSdpToken returns an error, the function returns nil and the error.
-	heToken, err := HeLoginToken()
+	heToken, err := HeLoginToken(operator)
 	if err != nil {
 		return nil, err
 	}
-	sdpToken, err := GetSdpToken()
+	sdpToken, err := GetSdpToken(operator)
 	if err != nil {
 		return nil, err
 	}
@@ -135,7 +69,7 @@ func BuildHeaders(requesId string) (map[string][]string, error) {
 	headers := map[string][]string{
 		"Authorization":                 {fmt.Sprintf("Bearer %s", heToken)},// Bearer token for HE login.
 		"X-api-auth-token":              {fmt.Sprintf("Bearer %s", sdpToken)},//Bearer token for SDP authentication.
-		"X-Api-Key":                     {os.Getenv("X_API_KEY")},// API key
+		"X-Api-Key":                     {operator.APIKey},// API key
 		"Accept-Encoding":               {"application/json"},//: Indicates the encoding of the response that the client can understand.
 		"Accept-Language":               {"EN"},// Language preferences of the client.
 		"Content-Type":                  {"application/json"},//Type of data being sent in the request payload.
@@ -143,62 +77,52 @@ func BuildHeaders(requesId string) (map[string][]string, error) {
 		"X-Correlation-Conversation-ID": {requesId},// Conversation ID to correlate requests and responses.
 		"X-MessageID":                   {requesId},// Unique identifier of the request.
 		"X-Source-Division":             {"DIT"},//Division name.
-		"X-Source-CountryCode":          {"KE"},//Country code of the source.
-		"X-Source-Operator":             {"Safaricom"},//Operator of the source.
+		"X-Source-CountryCode":          {operator.CountryCode},//Country code of the source.
+		"X-Source-Operator":             {operator.Name},//Operator of the source.
 		"X-Source-System":               {"web-portal"},// System name of the source.
 		"X-Source-Timestamp":            {fmt.Sprintf("%d", time.Now().Unix())},//Timestamp of the request.
 		"X-Version":                     {"1.0.0"},// API version
 	}
+	for name, value := range operator.Headers {
+		headers[name] = []string{value}
+	}
 	return headers, nil
 }
 func SendDeActivation(activation *models.HeRequest, channel string) (heResponse models.HeResponse, err error) {
-	url := fmt.Sprintf("%s/api/v1/deactivate", os.Getenv("HE_BASE_URL"))
-	payoad := fmt.Sprintf(`{
-		"msisdn": "%s",
-		"offerCode": "%s",
-		"CpId": "%s",
-		"callBackUrl": "%s"
-	}`, activation.Msisdn, activation.OfferCode, os.Getenv("CPID"), os.Getenv("ACT_DEACT_NOTIFICATION"))
-
-	headers, err := BuildHeaders(activation.ExternalID)
-
+	gateway, err := requestGateway(activation)
+	if err != nil {
+		return
+	}
+	previous, err := claimSubscription(activation, channel, models.SubscriptionPendingDeactivation)
 	if err != nil {
 		return
 	}
 
-	response, err := utils.Request(payoad, headers, url, "POST")
+	response, err := gateway.Deactivate(activation)
 	if err != nil {
 		logrus.Error(err)
+		// the subscription stays pending when the SDP may have applied the deactivation, its notification settles it
+		if failureStatus(err) != models.IdempotencyUnknown {
+			releaseSubscription(activation, channel, models.SubscriptionPendingDeactivation, previous, response)
+		}
 		return
 	}
-	heResponse, err = HeResponseProcessing(activation, response, channel)
+	heResponse, err = HeResponseProcessing(activation, response, channel, models.SubscriptionPendingDeactivation)
 	return
 
 }
 
 //Below function responsible for sending a charging request to the HE API.
 func SendCharging(chargeRequest *models.HeRequest) (heResponse models.HeResponse, err error) {
-	//construct  the URL to the HE API endpoint for charging requests using the HE_BASE_URL environment variable.
-	url := fmt.Sprintf("%s/api/v1/charge", os.Getenv("HE_BASE_URL"))
 	// heResponse := models.HeResponse{}
 	subscription := models.Subscription{}
-	//create payload
-	payoad := fmt.Sprintf(`{
-		"msisdn": "%s",
-		"offerCode": "%s",
-		"CpId": "%s",
-		"ChargeAmount": "%s",
-		"callBackUrl": "%s"
-	}`, chargeRequest.Msisdn, chargeRequest.OfferCode, os.Getenv("CPID"), chargeRequest.ChargeAmount, os.Getenv("CHARGE_CALLBACK"))
-
-	//build headers for the request using the BuildHeaders function, passing the ExternalID value from the chargeRequest parameter.
-	headers, err := BuildHeaders(chargeRequest.ExternalID)
-
+	gateway, err := requestGateway(chargeRequest)
 	if err != nil {
 		return
 	}
-	//the charging request to the HE API using the utils.Request function, passing the payload, headers, URL, and the HTTP method (POST).
-	response, err := utils.Request(payoad, headers, url, "POST")
+	//send the charging request through the SDP gateway of the subscriber's operator.
+	//charges are not idempotent so they are only retried when the SDP surely did not receive them.
+	response, err := gateway.Charge(chargeRequest)
 	if err != nil {
 		return
 	}
@@ -225,15 +149,100 @@ func SendCharging(chargeRequest *models.HeRequest) (heResponse models.HeResponse
 		Amount:            chargeRequest.ChargeAmount,
 		Callback:          chargeRequest.CallBackUrl,
 	}
-	//save the Transaction object to the database using the SaveTransaction method.
-	_, err = transaction.SaveTransaction()
+	//save the Transaction object to the database using the SaveTransaction function.
+	_, err = SaveTransaction(&transaction)
 	return
 
 }
 
+//This function saves a transaction record to the database. It lives here rather than on models.Transaction so
+//that package models does not depend on the database.
+func SaveTransaction(trx *models.Transaction) (*models.Transaction, error) {
+//It simply calls the Save() method on the transactions table and returns the result.
+	if err := database.Db.Debug().Table("transactions").Save(trx).Error; err != nil {
+		logrus.Error(err)
+		return trx, err
+	}
+	return trx, nil
+}
+
+
+// claimSubscription moves the subscription of the request to the pending state status before the request is sent,
+// so that concurrent requests for it cannot both reach the SDP. It returns models.ErrInvalidTransition when the
+// subscription cannot move to status, models.ErrTransitionInProgress when another request claimed it, and the
+// state it was claimed from otherwise. A claim left pending for SUBSCRIPTION_CLAIM_TIMEOUT (10m) may be taken over.
+func claimSubscription(request *models.HeRequest, channel, status string) (previous string, err error) {
+	sub := models.Subscription{
+		ExternalID: request.ExternalID,
+		PlanID:     request.OfferCode,
+		MSISDN:     request.Msisdn,
+		Callback:   request.CallBackUrl,
+		Method:     channel,
+	}
+	err = database.Db.Transaction(func(tx *gorm.DB) (err error) {
+		previous, err = sub.Claim(tx, status, channel, utils.GetEnvDuration("SUBSCRIPTION_CLAIM_TIMEOUT", 10*time.Minute))
+		return
+	})
+	return previous, notSent(err)
+}
+
+// sdpRequestError adds the answer of the SDP to the error of a failed request. The error chain is kept, as
+// failureStatus reads it to tell requests the SDP refused from those it never received.
+func sdpRequestError(err error, response string) error {
+	if response == "" {
+		return err
+	}
+	return fmt.Errorf("%w: %s", err, response)
+}
+
+// releaseSubscription puts the subscription back in the state it was claimed from, when the request did not reach
+// the SDP or the SDP refused it.
+func releaseSubscription(request *models.HeRequest, channel, status, previous, response string) {
+	sub := models.Subscription{
+		PlanID: request.OfferCode,
+		MSISDN: request.Msisdn,
+	}
+	err := database.Db.Transaction(func(tx *gorm.DB) error {
+		return sub.Revert(tx, status, previous, channel, response)
+	})
+	if err != nil {
+		logrus.Error(err)
+	}
+}
+
+// activationFailed settles the subscription claimed for an activation that failed with err: the claim is released
+// when the request did not reach the SDP, the subscription stays pending when the SDP may have applied it, as its
+// notification settles it, and it moves to failed only when the SDP refused it.
+func activationFailed(activation *models.HeRequest, channel, previous, response string, err error) {
+	switch failureStatus(err) {
+	case "":
+		releaseSubscription(activation, channel, models.SubscriptionPendingActivation, previous, response)
+	case models.IdempotencyFailed:
+		recordFailedActivation(activation, response, channel)
+	}
+}
+
+// recordFailedActivation moves the subscription to failed when the SDP rejects an activation request.
+func recordFailedActivation(activation *models.HeRequest, response, channel string) {
+	sub := models.Subscription{
+		ExternalID:        activation.ExternalID,
+		PlanID:            activation.OfferCode,
+		MSISDN:            activation.Msisdn,
+		Callback:          activation.CallBackUrl,
+		Method:            channel,
+		StatusDescription: "Activation request rejected by SDP",
+	}
+	err := database.Db.Transaction(func(tx *gorm.DB) error {
+		return sub.Transition(tx, models.SubscriptionFailed, channel, response)
+	})
+	if err != nil {
+		logrus.Error(err)
+	}
+}
 
 // below function response from the HE API after an activation or deactivation request has been made.
-func HeResponseProcessing(activation *models.HeRequest, response, channel string) (heResponse models.HeResponse, err error) {
+// status is the state the subscription moves to now that the SDP accepted the request.
+func HeResponseProcessing(activation *models.HeRequest, response, channel, status string) (heResponse models.HeResponse, err error) {
 	//unmarshal  the response into a models.HeResponse struct
 	err = json.Unmarshal([]byte(response), &heResponse)
 	if err != nil {
@@ -247,87 +256,146 @@ func HeResponseProcessing(activation *models.HeRequest, response, channel string
 		MSISDN:            activation.Msisdn,
 		Callback:          activation.CallBackUrl,
 		Method:            channel,
-		Status:            heResponse.Body.Status,
 		StatusDescription: heResponse.Body.Description,
 	}
 
-	//save  subscription to the database using the SaveSubscription method of the Subscription struct.
-	_, err = sub.SaveSubscription()
+	//save the subscription and its status change to the database, the channel is recorded as the source of the change.
+	err = database.Db.Transaction(func(tx *gorm.DB) error {
+		return sub.Transition(tx, status, channel, response)
+	})
 	return
 
 }
 
 //below function that performs a web activation request to a third-party service.
 func WebActivation(activation *models.HeRequest, channel string) (heResponse models.HeResponse, err error) {
-// build the URL to send the activation request 
-	url := fmt.Sprintf("%s/api/v1/wapActivate", os.Getenv("HE_BASE_URL"))
-//build the payload to send in the request.
-	payoad := fmt.Sprintf(`{
-		"msisdn": "%s",
-		"offerCode": "%s",
-		"CpId": "%s",
-		"callBackUrl": "%s"
-	}`, activation.Msisdn, activation.OfferCode, os.Getenv("CPID"), os.Getenv("ACT_DEACT_NOTIFICATION"))
-// build the request headers using the BuildHeaders function
-	headers, err := BuildHeaders(activation.ExternalID)
-
+// route the request to the operator of the plan and subscriber, and make sure we can authenticate with its SDP
+	gateway, err := requestGateway(activation)
 	if err != nil {
 		logrus.Error(err)
 		return
 	}
-// send the request using the utils.Request function.
-	response, err := utils.Request(payoad, headers, url, "POST")
+	previous, err := claimSubscription(activation, channel, models.SubscriptionPendingActivation)
+	if err != nil {
+		return
+	}
+// send the request through the SDP gateway.
+	response, err := gateway.WapActivate(activation)
 	if err != nil {
 		logrus.Error(err)
-		err = errors.New(response)
+		err = sdpRequestError(err, response)
+		activationFailed(activation, channel, previous, response, err)
 		return
 	}
 	// successful requests processes the response using the HeResponseProcessing function and returns a models.HeResponse struct.
-	heResponse, err = HeResponseProcessing(activation, response, channel)
+	heResponse, err = HeResponseProcessing(activation, response, channel, models.SubscriptionPendingActivation)
 	return
 
 }
 
 //Below function takes in a callback notification received from an external system and updates the subscription status in the local database accordingly.
-func ActDeactProcess(notification models.Callback) {
-// initialize empty Subscription struct
-	subscription := models.Subscription{}
-
-//extract relevant information from the notification
-	for _, data := range notification.RequestParam.Data {
-		switch data.Name {
-		case "ClientTransactionId":
-			subscription.ExternalID = data.Value.(string)
-		case "OfferCode":
-			subscription.PlanID = data.Value.(string)
-		case "SubscriptionStatus":
-			subscription.Status = data.Value.(string)
-		}
+//data is the notification's requestParam.data decoded with models.DecodeSubscriptionNotification.
+//It returns an error when the notification could not be applied, so that the SDP delivers it again.
+func ActDeactProcess(notification models.Callback, data models.SubscriptionNotification) error {
+// initialize the Subscription struct from the notification data
+	subscription := models.Subscription{
+		ExternalID: data.ClientTransactionId,
+		PlanID:     data.OfferCode,
+		Status:     data.SubscriptionStatus,
 	}
-//check subscription status and set the status description accordingly.
-	if subscription.Status == "A" {
+//map the SDP subscription status onto our states and set the status description accordingly.
+	status := models.SubscriptionDeactivated
+	switch subscription.Status {
+	case "A":
+		status = models.SubscriptionActive
 		subscription.StatusDescription = "Subscriber in active state"
-	} else {
-		subscription.Status = "D"
+	case "S":
+		status = models.SubscriptionSuspended
+		subscription.StatusDescription = "Subscriber in suspended state"
+	default:
 		subscription.StatusDescription = "Subscriber in Deactive state"
 	}
 
 //query the local database for a subscription matching the external ID and plan ID provided in the notification. 
 // NOTE: Better to use partner_id and external_id.
 // But plan_id is okay given that it is a 1 to 1 representation of customer.
-	if err := database.Db.Debug().Table("subscriptions").Where("external_id = ? plan_id = ?", subscription.ExternalID, subscription.PlanID).First(&subscription).Error; err != nil {
-		logrus.Error(err)
-		return
+	existing, err := subscriptionByExternalID(subscription.ExternalID, subscription.PlanID)
+	if err != nil {
+		return err
 	}
-// if a matching subscription is found, update the status and status description with the values extracted from the notification
-	err := database.Db.Debug().Table("subscriptions").Where("external_id = ? plan_id = ?", subscription.ExternalID, subscription.PlanID).Updates(&subscription).Error
+	partnerId, err := PlanPartnerID(existing.PlanID)
 	if err != nil {
-		logrus.Error(err)
+		return err
+	}
+// update the status and queue the notification for the partner in one transaction, so the partner is told about every committed change.
+	payload, _ := json.Marshal(notification)
+	update := models.Subscription{
+		ID:                existing.ID,
+		StatusDescription: subscription.StatusDescription,
+	}
+	return database.Db.Transaction(func(tx *gorm.DB) error {
+		if err := recordNotification(tx, notification.RequestId); err != nil {
+			return err
+		}
+		// the SDP is authoritative: its state is recorded and the partner told even when we did not expect it
+		from, anomaly, err := update.Record(tx, status, models.SourceSDPCallback, string(payload))
+		if err != nil {
+			return err
+		}
+		if anomaly {
+			logrus.Warnf("SUBSCRIPTION STATE ANOMALY | ID : %d | %q -> %q REPORTED BY SDP", existing.ID, from, status)
+		}
+		return EnqueueCallback(tx, partnerId, existing.Callback, payload)
+	})
+}
+
+// subscriptionByExternalID finds the subscription of the plan with the external ID, or the one it was merged into.
+func subscriptionByExternalID(externalId, planId string) (subscription models.Subscription, err error) {
+	err = database.Db.Debug().Table("subscriptions").Where("external_id = ? AND plan_id = ?", externalId, planId).First(&subscription).Error
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
 		return
 	}
-//marshals the notification payload to JSON and sends a POST request to the subscription's callback URL with the updated information.
+	err = database.Db.Debug().Table("subscriptions").Select("subscriptions.*").
+		Joins("JOIN subscription_aliases ON subscription_aliases.subscription_id = subscriptions.id").
+		Where("subscription_aliases.external_id = ? AND subscription_aliases.plan_id = ?", externalId, planId).
+		First(&subscription).Error
+	return
+}
+
+//Below function takes in a charge notification from the SDP, updates the matching transaction and queues the notification for the partner.
+//data is the notification's requestParam.data decoded with models.DecodeChargeNotification.
+//It returns an error when the notification could not be applied, so that the SDP delivers it again.
+func ChargeProcess(notification models.Callback, data models.ChargeNotification) error {
+	transaction := models.Transaction{
+		ExternalID: data.ClientTransactionId,
+		Status:     data.Reason,
+	}
+	offercode := data.OfferCode
+
+	if transaction.Status == "Successful" {
+		transaction.StatusDescription = "Subscriber charged"
+	} else {
+		transaction.StatusDescription = transaction.Status
+	}
+
+	// NOTE: Better to use partner_id and external_id.
+	// But plan_id is okay given that it is a 1 to 1 representation of customer.
+	existing := models.Transaction{}
+	if err := database.Db.Debug().Table("transactions_v").Where("external_id = ? AND plan_id = ?", transaction.ExternalID, offercode).First(&existing).Error; err != nil {
+		return err
+	}
+	partnerId, err := PlanPartnerID(offercode)
+	if err != nil {
+		return err
+	}
 	payload, _ := json.Marshal(notification)
-	utils.Request(string(payload), map[string][]string{
-		"Content-Type": {"application/json"},
-	}, subscription.Callback, "POST")
-}
\ No newline at end of file
+	return database.Db.Transaction(func(tx *gorm.DB) error {
+		if err := recordNotification(tx, notification.RequestId); err != nil {
+			return err
+		}
+		if err := tx.Debug().Table("transactions").Where("id = ?", existing.ID).Updates(&transaction).Error; err != nil {
+			return err
+		}
+		return EnqueueCallback(tx, partnerId, existing.Callback, payload)
+	})
+}
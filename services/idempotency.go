@@ -0,0 +1,167 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm/clause"
+
+	"github.com/apeli23/infinity/database"
+	"github.com/apeli23/infinity/models"
+	"github.com/apeli23/infinity/utils"
+)
+
+var (
+	ErrIdempotencyConflict   = errors.New("requestId already used with a different request")
+	ErrIdempotencyInProgress = errors.New("a request with this requestId is still being processed")
+	ErrIdempotencyUnknown    = errors.New("the outcome of the request with this requestId is unknown, check the subscription before sending it again with a new requestId")
+)
+
+// notSentError marks a failure that happened before the request was sent to the SDP.
+type notSentError struct {
+	error
+}
+
+func (e notSentError) Unwrap() error {
+	return e.error
+}
+
+// notSent marks err as a failure that happened before the request was sent to the SDP, see Idempotent.
+func notSent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return notSentError{err}
+}
+
+// failureStatus classifies a failed call: "" when the SDP surely did not process the request, failed when it
+// refused it and unknown when it may have applied it (lost response, failure after the SDP answered).
+func failureStatus(err error) string {
+	var skipped notSentError
+	if errors.As(err, &skipped) || utils.NotProcessed(err) {
+		return ""
+	}
+	var httpErr *utils.HTTPError
+	if errors.As(err, &httpErr) && httpErr.StatusCode >= 400 && httpErr.StatusCode < 500 {
+		return models.IdempotencyFailed
+	}
+	return models.IdempotencyUnknown
+}
+
+// requestHash fingerprints an operation and its body so a reused requestId with a different request can be detected.
+func requestHash(operation string, request *models.HeRequest) string {
+	body, _ := json.Marshal(request)
+	sum := sha256.Sum256(append([]byte(operation+":"), body...))
+	return hex.EncodeToString(sum[:])
+}
+
+// takeOverKey takes over a key left processing for longer than IDEMPOTENCY_LEASE (5m), by a process that died
+// mid-call or failed to record the outcome. Charges are not sent again as the SDP may have applied them: their
+// key is settled as unknown. It returns ErrIdempotencyInProgress while the lease runs or another caller took over.
+func takeOverKey(key models.IdempotencyKey) error {
+	cutoff := time.Now().Add(-utils.GetEnvDuration("IDEMPOTENCY_LEASE", 5*time.Minute))
+	if key.UpdatedAt.After(cutoff) {
+		return ErrIdempotencyInProgress
+	}
+
+	update := map[string]interface{}{"updated_at": time.Now()}
+	if key.Operation == "charge" {
+		update["status"] = models.IdempotencyUnknown
+	}
+	result := database.Db.Table("idempotency_keys").
+		Where("id = ? AND status = ? AND updated_at < ?", key.ID, models.IdempotencyProcessing, cutoff).
+		Updates(update)
+	switch {
+	case result.Error != nil:
+		return result.Error
+	case result.RowsAffected == 0:
+		return ErrIdempotencyInProgress
+	case key.Operation == "charge":
+		logrus.Warnf("IDEMPOTENCY KEY EXPIRED | PARTNER : %s | REQUEST : %s | CHARGE SETTLED AS UNKNOWN", key.PartnerID, key.RequestID)
+		return ErrIdempotencyUnknown
+	}
+	logrus.Warnf("IDEMPOTENCY KEY EXPIRED | PARTNER : %s | REQUEST : %s | TAKEN OVER", key.PartnerID, key.RequestID)
+	return nil
+}
+
+// Idempotent runs call at most once per partner and requestId (HeRequest.ExternalID).
+// The first outcome is stored and replayed for every retry of the same request, in which case replayed is true:
+// the response of a successful call, the error the SDP refused the request with, or ErrIdempotencyUnknown when
+// the SDP may have applied it. Only a call that failed before the SDP processed the request releases the key,
+// so the partner can retry it.
+func Idempotent(partnerId, operation string, request *models.HeRequest, call func() (models.HeResponse, error)) (response models.HeResponse, replayed bool, err error) {
+	key := models.IdempotencyKey{
+		PartnerID:   partnerId,
+		RequestID:   request.ExternalID,
+		Operation:   operation,
+		RequestHash: requestHash(operation, request),
+		Status:      models.IdempotencyProcessing,
+	}
+
+	result := database.Db.Debug().Table("idempotency_keys").Clauses(clause.OnConflict{DoNothing: true}).Create(&key)
+	if result.Error != nil {
+		err = result.Error
+		return
+	}
+
+	// another request already reserved this requestId
+	if result.RowsAffected == 0 {
+		existing := models.IdempotencyKey{}
+		if err = database.Db.Debug().Table("idempotency_keys").Where("partner_id = ? AND request_id = ?", partnerId, request.ExternalID).First(&existing).Error; err != nil {
+			return
+		}
+		switch {
+		case existing.RequestHash != key.RequestHash:
+			err = ErrIdempotencyConflict
+			return
+		case existing.Status == models.IdempotencyCompleted:
+			replayed = true
+			err = json.Unmarshal([]byte(existing.Response), &response)
+			return
+		case existing.Status == models.IdempotencyFailed:
+			replayed = true
+			err = errors.New(existing.Response)
+			return
+		case existing.Status == models.IdempotencyUnknown:
+			replayed = true
+			err = ErrIdempotencyUnknown
+			return
+		}
+		if err = takeOverKey(existing); err != nil {
+			return
+		}
+	}
+
+	where := database.Db.Debug().Table("idempotency_keys").Where("partner_id = ? AND request_id = ?", partnerId, request.ExternalID)
+	// settle records the outcome of the call; when it fails the key is taken over once its lease runs out
+	settle := func(status, stored string) {
+		if updateErr := where.Updates(map[string]interface{}{
+			"status":     status,
+			"response":   stored,
+			"updated_at": time.Now(),
+		}).Error; updateErr != nil {
+			logrus.Error(updateErr)
+		}
+	}
+
+	response, err = call()
+	if err != nil {
+		status := failureStatus(err)
+		if status == "" {
+			if releaseErr := where.Delete(&models.IdempotencyKey{}).Error; releaseErr != nil {
+				logrus.Error(releaseErr)
+			}
+			return
+		}
+		settle(status, err.Error())
+		return
+	}
+
+	stored, _ := json.Marshal(response)
+	settle(models.IdempotencyCompleted, string(stored))
+	return
+}
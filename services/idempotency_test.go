@@ -0,0 +1,99 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/apeli23/infinity/models"
+	"github.com/apeli23/infinity/simulator"
+	"github.com/apeli23/infinity/utils"
+)
+
+func TestFailureStatus(t *testing.T) {
+	rejected := func(code int) error {
+		return &utils.HTTPError{StatusCode: code, Body: `{"body": {"status": "FAILED"}}`}
+	}
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "invalid request", err: notSent(fmt.Errorf("%w: msisdn must only contain digits", models.ErrInvalidSdpRequest)), want: ""},
+		{name: "no operator", err: notSent(ErrNoOperator), want: ""},
+		{name: "subscription claimed", err: notSent(models.ErrTransitionInProgress), want: ""},
+		{name: "sdp login failed", err: notSent(rejected(http.StatusUnauthorized)), want: ""},
+		{name: "circuit open", err: utils.ErrCircuitOpen, want: ""},
+		{name: "host busy", err: fmt.Errorf("%w: sdp.example.com", utils.ErrHostBusy), want: ""},
+		{name: "connection refused", err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, want: ""},
+		{name: "too many requests", err: rejected(http.StatusTooManyRequests), want: ""},
+		{name: "service unavailable", err: rejected(http.StatusServiceUnavailable), want: ""},
+		{name: "bad request", err: rejected(http.StatusBadRequest), want: models.IdempotencyFailed},
+		{name: "unauthorized", err: rejected(http.StatusUnauthorized), want: models.IdempotencyFailed},
+		{name: "conflict", err: rejected(http.StatusConflict), want: models.IdempotencyFailed},
+		{name: "internal server error", err: rejected(http.StatusInternalServerError), want: models.IdempotencyUnknown},
+		{name: "gateway timeout", err: rejected(http.StatusGatewayTimeout), want: models.IdempotencyUnknown},
+		{name: "connection reset", err: &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")}, want: models.IdempotencyUnknown},
+		{name: "timeout", err: simulator.ErrTimeout, want: models.IdempotencyUnknown},
+		{name: "unclassified", err: errors.New("unexpected end of JSON input"), want: models.IdempotencyUnknown},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := failureStatus(test.err); got != test.want {
+				t.Errorf("failureStatus(%v) = %q, want %q", test.err, got, test.want)
+			}
+			// activations return the error with the SDP answer appended
+			if got := failureStatus(sdpRequestError(test.err, `{"header": {}}`)); got != test.want {
+				t.Errorf("failureStatus(sdpRequestError(%v)) = %q, want %q", test.err, got, test.want)
+			}
+		})
+	}
+}
+
+func TestFailureStatusOfSimulatedSDP(t *testing.T) {
+	t.Setenv("SDP_SIMULATOR_LATENCY", "0s")
+	t.Setenv("SDP_SIMULATOR_CALLBACK_DELAY", "1h")
+	sdp := simulator.New()
+	request := func(msisdn, offerCode string) *models.HeRequest {
+		return &models.HeRequest{ExternalID: "r1", Msisdn: msisdn, OfferCode: offerCode, CallBackUrl: "https://partner.example.com", ChargeAmount: "10"}
+	}
+	t.Setenv("ACT_DEACT_NOTIFICATION", "https://he.example.com/notification")
+	t.Setenv("CHARGE_CALLBACK", "https://he.example.com/charge")
+
+	if _, err := sdp.Activate(request("254712345678", "1029")); err != nil {
+		t.Fatalf("Activate() error = %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		failureMode string
+		call        func() (string, error)
+		want        string
+	}{
+		{name: "already active", call: func() (string, error) { return sdp.Activate(request("254712345678", "1029")) }, want: models.IdempotencyFailed},
+		{name: "not active", call: func() (string, error) { return sdp.Deactivate(request("254711111111", "1029")) }, want: models.IdempotencyFailed},
+		{name: "invalid msisdn", call: func() (string, error) { return sdp.Activate(request("0712'", "1029")) }, want: models.IdempotencyFailed},
+		{name: "charge of an inactive subscriber", call: func() (string, error) { return sdp.Charge(request("254711111111", "1029")) }, want: models.IdempotencyFailed},
+		{name: "unavailable", failureMode: "error", call: func() (string, error) { return sdp.Activate(request("254722222222", "1029")) }, want: ""},
+		{name: "refused", failureMode: "reject", call: func() (string, error) { return sdp.Activate(request("254722222222", "1029")) }, want: models.IdempotencyFailed},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.failureMode != "" {
+				t.Setenv("SDP_SIMULATOR_FAILURE_RATE", "1")
+				t.Setenv("SDP_SIMULATOR_FAILURE_MODE", test.failureMode)
+			}
+			response, err := test.call()
+			if err == nil {
+				t.Fatalf("call succeeded: %s", response)
+			}
+			if got := failureStatus(sdpRequestError(err, response)); got != test.want {
+				t.Errorf("failureStatus(%v) = %q, want %q", err, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,391 @@
+package services
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	crand "crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+
+	"github.com/apeli23/infinity/database"
+	"github.com/apeli23/infinity/models"
+	"github.com/apeli23/infinity/utils"
+)
+
+var (
+	ErrNoSigningKey       = errors.New("no active token signing key")
+	ErrUnknownKeyID       = errors.New("unknown token key id")
+	ErrKeyAlgorithm       = errors.New("token algorithm does not match its key")
+	ErrLegacyToken        = errors.New("tokens without a key id are no longer accepted")
+	ErrNoKeyEncryptionKey = errors.New("JWT_KEY_ENCRYPTION_KEY must be 32 bytes encoded in base64")
+)
+
+// keyRotationLock is the postgres advisory lock id held while generating a signing key,
+// so instances starting together do not all generate one.
+const keyRotationLock = 0x6a776b73
+
+// sealedKeyPrefix marks the private keys stored encrypted with JWT_KEY_ENCRYPTION_KEY, keys without it are the
+// plaintext PEM keys generated before and are sealed on the next reload.
+const sealedKeyPrefix = "aes256gcm:"
+
+// signingKey is a parsed models.JWTSigningKey.
+type signingKey struct {
+	kid       string
+	method    jwt.SigningMethod
+	private   crypto.Signer
+	notAfter  time.Time
+	expiresAt time.Time
+}
+
+// keyring holds the signing keys loaded from jwt_signing_keys.
+type keyring struct {
+	mu       sync.RWMutex
+	keys     map[string]signingKey
+	loadedAt time.Time
+}
+
+var tokenKeys = &keyring{keys: map[string]signingKey{}}
+
+// keyEncryption returns the AES-256-GCM cipher of the key encryption key JWT_KEY_ENCRYPTION_KEY.
+func keyEncryption() (cipher.AEAD, error) {
+	kek, err := base64.StdEncoding.DecodeString(os.Getenv("JWT_KEY_ENCRYPTION_KEY"))
+	if err != nil || len(kek) != 32 {
+		return nil, ErrNoKeyEncryptionKey
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealPrivateKey encrypts the PKCS #8 DER of a private key for storage. The kid is authenticated along with it
+// so a sealed key cannot be moved to another row.
+func sealPrivateKey(kid string, der []byte) (string, error) {
+	aead, err := keyEncryption()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := crand.Read(nonce); err != nil {
+		return "", err
+	}
+	return sealedKeyPrefix + base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, der, []byte(kid))), nil
+}
+
+// openPrivateKey returns the PKCS #8 DER of a stored private key and whether it was stored in plaintext.
+func openPrivateKey(stored models.JWTSigningKey) (der []byte, plaintext bool, err error) {
+	if !strings.HasPrefix(stored.PrivateKey, sealedKeyPrefix) {
+		block, _ := pem.Decode([]byte(stored.PrivateKey))
+		if block == nil {
+			return nil, true, fmt.Errorf("signing key %s: invalid PEM", stored.KID)
+		}
+		return block.Bytes, true, nil
+	}
+
+	aead, err := keyEncryption()
+	if err != nil {
+		return
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(stored.PrivateKey, sealedKeyPrefix))
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return nil, false, fmt.Errorf("signing key %s: invalid sealed key", stored.KID)
+	}
+	der, err = aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(stored.KID))
+	if err != nil {
+		return nil, false, fmt.Errorf("signing key %s: cannot be decrypted with JWT_KEY_ENCRYPTION_KEY", stored.KID)
+	}
+	return
+}
+
+// sealPlaintextKey encrypts a key stored in plaintext in place.
+func sealPlaintextKey(stored models.JWTSigningKey, der []byte) error {
+	sealed, err := sealPrivateKey(stored.KID, der)
+	if err != nil {
+		return err
+	}
+	return database.Db.Table("jwt_signing_keys").
+		Where("kid = ? AND private_key = ?", stored.KID, stored.PrivateKey).
+		Update("private_key", sealed).Error
+}
+
+func parseSigningKey(stored models.JWTSigningKey, der []byte) (key signingKey, err error) {
+	parsed, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return
+	}
+
+	key = signingKey{
+		kid:       stored.KID,
+		method:    jwt.GetSigningMethod(stored.Alg),
+		notAfter:  stored.NotAfter,
+		expiresAt: stored.ExpiresAt,
+	}
+	switch private := parsed.(type) {
+	case *ecdsa.PrivateKey:
+		key.private = private
+	case *rsa.PrivateKey:
+		key.private = private
+	default:
+		return key, fmt.Errorf("signing key %s: unsupported key type %T", stored.KID, parsed)
+	}
+	if key.method == nil {
+		return key, fmt.Errorf("signing key %s: unsupported algorithm %s", stored.KID, stored.Alg)
+	}
+	return
+}
+
+// reload replaces the keyring with the keys that have not expired yet.
+func (k *keyring) reload() error {
+	var stored []models.JWTSigningKey
+	if err := database.Db.Table("jwt_signing_keys").Where("expires_at > ?", time.Now()).Find(&stored).Error; err != nil {
+		return err
+	}
+
+	keys := make(map[string]signingKey, len(stored))
+	for _, entry := range stored {
+		der, plaintext, err := openPrivateKey(entry)
+		if err != nil {
+			logrus.Error(err)
+			continue
+		}
+		key, err := parseSigningKey(entry, der)
+		if err != nil {
+			logrus.Error(err)
+			continue
+		}
+		if plaintext {
+			if err := sealPlaintextKey(entry, der); err != nil {
+				logrus.Errorf("signing key %s is stored in plaintext: %v", entry.KID, err)
+			}
+		}
+		keys[key.kid] = key
+	}
+
+	k.mu.Lock()
+	k.keys = keys
+	k.loadedAt = time.Now()
+	k.mu.Unlock()
+	return nil
+}
+
+// stale reports whether the keyring was loaded long enough ago to be worth reloading for an unknown kid.
+// This keeps tokens with made-up kids from turning into a database query each.
+func (k *keyring) stale() bool {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return time.Since(k.loadedAt) > 10*time.Second
+}
+
+// signing returns the newest key still allowed to sign.
+func (k *keyring) signing() (key signingKey, err error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	now := time.Now()
+	found := false
+	for _, candidate := range k.keys {
+		if candidate.notAfter.After(now) && (!found || candidate.notAfter.After(key.notAfter)) {
+			key, found = candidate, true
+		}
+	}
+	if !found {
+		err = ErrNoSigningKey
+	}
+	return
+}
+
+func (k *keyring) get(kid string) (signingKey, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	key, ok := k.keys[kid]
+	return key, ok && key.expiresAt.After(time.Now())
+}
+
+// generateSigningKey creates a key pair for JWT_SIGNING_ALG (ES256 by default, or RS256), its private key sealed with JWT_KEY_ENCRYPTION_KEY.
+func generateSigningKey() (stored models.JWTSigningKey, err error) {
+	alg := os.Getenv("JWT_SIGNING_ALG")
+	if alg == "" {
+		alg = jwt.SigningMethodES256.Alg()
+	}
+
+	var private crypto.Signer
+	switch alg {
+	case jwt.SigningMethodES256.Alg():
+		private, err = ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	case jwt.SigningMethodRS256.Alg():
+		private, err = rsa.GenerateKey(crand.Reader, 2048)
+	default:
+		err = fmt.Errorf("unsupported JWT_SIGNING_ALG %s", alg)
+	}
+	if err != nil {
+		return
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return
+	}
+	kid, err := GenerateSecret(8)
+	if err != nil {
+		return
+	}
+
+	sealed, err := sealPrivateKey(kid, der)
+	if err != nil {
+		return
+	}
+
+	notAfter := time.Now().Add(utils.GetEnvDuration("JWT_KEY_ROTATION", 30*24*time.Hour))
+	stored = models.JWTSigningKey{
+		KID:        kid,
+		Alg:        alg,
+		PrivateKey: sealed,
+		NotAfter:   notAfter,
+		ExpiresAt:  notAfter.Add(AccessTokenTTL),
+	}
+	return
+}
+
+// RotateSigningKeys makes sure a signing key remains usable for at least JWT_KEY_ROTATION_OVERLAP (24h by default)
+// by generating the next key ahead of time, then reloads the keyring. The previous key keeps verifying tokens until they expire.
+func RotateSigningKeys() error {
+	overlap := utils.GetEnvDuration("JWT_KEY_ROTATION_OVERLAP", 24*time.Hour)
+
+	err := database.Db.Transaction(func(tx *gorm.DB) error {
+		locked := false
+		if err := tx.Raw("SELECT pg_try_advisory_xact_lock(?)", keyRotationLock).Scan(&locked).Error; err != nil || !locked {
+			return err
+		}
+
+		var upcoming int64
+		if err := tx.Table("jwt_signing_keys").Where("not_after > ?", time.Now().Add(overlap)).Count(&upcoming).Error; err != nil {
+			return err
+		}
+		if upcoming > 0 {
+			return nil
+		}
+
+		key, err := generateSigningKey()
+		if err != nil {
+			return err
+		}
+		logrus.Infof("JWT KEY ROTATION | KID : %s | ALG : %s | NOT AFTER : %s", key.KID, key.Alg, key.NotAfter)
+		return tx.Table("jwt_signing_keys").Create(&key).Error
+	})
+	if err != nil {
+		return err
+	}
+	return tokenKeys.reload()
+}
+
+// SignToken signs token with the current signing key and sets its kid header.
+func SignToken(token *jwt.Token) (string, error) {
+	key, err := tokenKeys.signing()
+	if errors.Is(err, ErrNoSigningKey) {
+		// first token of this instance or the rotation loop fell behind
+		if err = RotateSigningKeys(); err == nil {
+			key, err = tokenKeys.signing()
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+
+	token.Method = key.method
+	token.Header["alg"] = key.method.Alg()
+	token.Header["kid"] = key.kid
+	return token.SignedString(key.private)
+}
+
+// VerificationKey is the jwt.Keyfunc of ValidateToken. It picks the public key by the kid header; tokens signed
+// with AUTH_SECRET before the switch to asymmetric keys are accepted for as long as AUTH_SECRET is set.
+func VerificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		secret := os.Getenv("AUTH_SECRET")
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok || secret == "" {
+			return nil, ErrLegacyToken
+		}
+		return []byte(secret), nil
+	}
+
+	key, ok := tokenKeys.get(kid)
+	if !ok && tokenKeys.stale() {
+		// the key may have just been generated by another instance
+		if err := tokenKeys.reload(); err != nil {
+			return nil, err
+		}
+		key, ok = tokenKeys.get(kid)
+	}
+	if !ok {
+		return nil, ErrUnknownKeyID
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, ErrKeyAlgorithm
+	}
+	return key.private.Public(), nil
+}
+
+func base64URLInt(value *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(value.Bytes())
+}
+
+// JWKS returns the public keys of every key tokens may currently be signed with.
+func JWKS() models.JWKS {
+	tokenKeys.mu.RLock()
+	defer tokenKeys.mu.RUnlock()
+
+	jwks := models.JWKS{Keys: []models.JWK{}}
+	now := time.Now()
+	for _, key := range tokenKeys.keys {
+		if key.expiresAt.Before(now) {
+			continue
+		}
+		jwk := models.JWK{Kid: key.kid, Alg: key.method.Alg(), Use: "sig"}
+		switch public := key.private.Public().(type) {
+		case *ecdsa.PublicKey:
+			size := (public.Curve.Params().BitSize + 7) / 8
+			jwk.Kty = "EC"
+			jwk.Crv = public.Curve.Params().Name
+			jwk.X = base64.RawURLEncoding.EncodeToString(public.X.FillBytes(make([]byte, size)))
+			jwk.Y = base64.RawURLEncoding.EncodeToString(public.Y.FillBytes(make([]byte, size)))
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64URLInt(public.N)
+			jwk.E = base64URLInt(big.NewInt(int64(public.E)))
+		}
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	return jwks
+}
+
+// StartSigningKeyRotation keeps the keyring fresh and rotates keys on schedule until the process exits.
+func StartSigningKeyRotation() {
+	go rotateSigningKeys()
+}
+
+func rotateSigningKeys() {
+	for {
+		if err := RotateSigningKeys(); err != nil {
+			logrus.Error(err)
+		}
+		<-time.After(utils.GetEnvDuration("JWT_KEYRING_REFRESH", time.Minute))
+	}
+}
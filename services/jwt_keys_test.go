@@ -0,0 +1,217 @@
+package services
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	crand "crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+
+	"github.com/apeli23/infinity/models"
+)
+
+func setKeyEncryptionKey(t *testing.T) {
+	t.Setenv("JWT_KEY_ENCRYPTION_KEY", base64.StdEncoding.EncodeToString(make([]byte, 32)))
+}
+
+// testSigningKey generates a key of alg the way RotateSigningKeys does and parses it like reload.
+func testSigningKey(t *testing.T, alg string, notAfter time.Time) signingKey {
+	t.Helper()
+	t.Setenv("JWT_SIGNING_ALG", alg)
+	stored, err := generateSigningKey()
+	if err != nil {
+		t.Fatalf("generateSigningKey() error = %v", err)
+	}
+	stored.NotAfter, stored.ExpiresAt = notAfter, notAfter.Add(AccessTokenTTL)
+	der, _, err := openPrivateKey(stored)
+	if err != nil {
+		t.Fatalf("openPrivateKey() error = %v", err)
+	}
+	key, err := parseSigningKey(stored, der)
+	if err != nil {
+		t.Fatalf("parseSigningKey() error = %v", err)
+	}
+	return key
+}
+
+// useKeyring replaces the keyring for the test, loaded now so unknown kids do not reload it from the database.
+func useKeyring(t *testing.T, keys ...signingKey) {
+	previous := tokenKeys
+	ring := &keyring{keys: map[string]signingKey{}, loadedAt: time.Now()}
+	for _, key := range keys {
+		ring.keys[key.kid] = key
+	}
+	tokenKeys = ring
+	t.Cleanup(func() { tokenKeys = previous })
+}
+
+func TestOpenPrivateKey(t *testing.T) {
+	setKeyEncryptionKey(t)
+	private, _ := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	der, _ := x509.MarshalPKCS8PrivateKey(private)
+	sealed, err := sealPrivateKey("kid-1", der)
+	if err != nil {
+		t.Fatalf("sealPrivateKey() error = %v", err)
+	}
+	plaintext := string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+
+	tests := []struct {
+		name          string
+		stored        models.JWTSigningKey
+		kek           string
+		wantPlaintext bool
+		wantErr       bool
+	}{
+		{name: "sealed", stored: models.JWTSigningKey{KID: "kid-1", PrivateKey: sealed}},
+		{name: "moved to another kid", stored: models.JWTSigningKey{KID: "kid-2", PrivateKey: sealed}, wantErr: true},
+		{name: "other encryption key", stored: models.JWTSigningKey{KID: "kid-1", PrivateKey: sealed}, kek: base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32)), wantErr: true},
+		{name: "corrupted", stored: models.JWTSigningKey{KID: "kid-1", PrivateKey: sealedKeyPrefix + "AAAA"}, wantErr: true},
+		{name: "plaintext", stored: models.JWTSigningKey{KID: "kid-1", PrivateKey: plaintext}, wantPlaintext: true},
+		{name: "invalid pem", stored: models.JWTSigningKey{KID: "kid-1", PrivateKey: "not a key"}, wantPlaintext: true, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.kek != "" {
+				t.Setenv("JWT_KEY_ENCRYPTION_KEY", test.kek)
+			}
+			got, isPlaintext, err := openPrivateKey(test.stored)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("openPrivateKey() error = %v, want error %v", err, test.wantErr)
+			}
+			if isPlaintext != test.wantPlaintext {
+				t.Errorf("plaintext = %v, want %v", isPlaintext, test.wantPlaintext)
+			}
+			if !test.wantErr && string(got) != string(der) {
+				t.Error("openPrivateKey() returned another key")
+			}
+		})
+	}
+}
+
+func TestKeyEncryptionRequired(t *testing.T) {
+	for _, kek := range []string{"", "not base64", base64.StdEncoding.EncodeToString(make([]byte, 16))} {
+		t.Setenv("JWT_KEY_ENCRYPTION_KEY", kek)
+		if _, err := sealPrivateKey("kid-1", []byte("key")); !errors.Is(err, ErrNoKeyEncryptionKey) {
+			t.Errorf("sealPrivateKey() with key %q error = %v, want %v", kek, err, ErrNoKeyEncryptionKey)
+		}
+	}
+}
+
+func TestVerificationKey(t *testing.T) {
+	setKeyEncryptionKey(t)
+	t.Setenv("AUTH_SECRET", "legacy-secret")
+	retired := testSigningKey(t, "RS256", time.Now().Add(-time.Minute))
+	current := testSigningKey(t, "ES256", time.Now().Add(time.Hour))
+	useKeyring(t, retired, current)
+
+	claims := jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute))}
+	signed, err := SignToken(jwt.NewWithClaims(jwt.SigningMethodHS256, claims))
+	if err != nil {
+		t.Fatalf("SignToken() error = %v", err)
+	}
+	signedWith := func(method jwt.SigningMethod, kid string, key interface{}) string {
+		token := jwt.NewWithClaims(method, claims)
+		if kid != "" {
+			token.Header["kid"] = kid
+		}
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatalf("SignedString() error = %v", err)
+		}
+		return signed
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		noAuth  bool
+		wantErr error
+	}{
+		{name: "current key", token: signed},
+		{name: "retired key still verifies", token: signedWith(retired.method, retired.kid, retired.private)},
+		{name: "unknown kid", token: signedWith(current.method, "unknown", current.private), wantErr: ErrUnknownKeyID},
+		{name: "algorithm swapped", token: signedWith(jwt.SigningMethodHS256, current.kid, []byte("guess")), wantErr: ErrKeyAlgorithm},
+		{name: "legacy secret", token: signedWith(jwt.SigningMethodHS256, "", []byte("legacy-secret"))},
+		{name: "legacy secret retired", token: signedWith(jwt.SigningMethodHS256, "", []byte("legacy-secret")), noAuth: true, wantErr: ErrLegacyToken},
+		{name: "no kid", token: signedWith(current.method, "", current.private), wantErr: ErrLegacyToken},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.noAuth {
+				t.Setenv("AUTH_SECRET", "")
+			}
+			_, err := jwt.ParseWithClaims(test.token, &jwt.RegisteredClaims{}, VerificationKey)
+			if test.wantErr == nil && err != nil {
+				t.Fatalf("ParseWithClaims() error = %v", err)
+			}
+			if test.wantErr != nil && !errors.Is(err, test.wantErr) {
+				t.Errorf("ParseWithClaims() error = %v, want %v", err, test.wantErr)
+			}
+		})
+	}
+
+	unverified, _, err := jwt.NewParser().ParseUnverified(signed, &jwt.RegisteredClaims{})
+	if err != nil {
+		t.Fatalf("ParseUnverified() error = %v", err)
+	}
+	if kid := unverified.Header["kid"]; kid != current.kid {
+		t.Errorf("token signed with kid %v, want the newest signing key %s", kid, current.kid)
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	setKeyEncryptionKey(t)
+	ec := testSigningKey(t, "ES256", time.Now().Add(time.Hour))
+	rs := testSigningKey(t, "RS256", time.Now().Add(time.Hour))
+	expired := testSigningKey(t, "ES256", time.Now().Add(-2*AccessTokenTTL))
+	useKeyring(t, ec, rs, expired)
+
+	keys := map[string]models.JWK{}
+	for _, jwk := range JWKS().Keys {
+		keys[jwk.Kid] = jwk
+	}
+	if _, ok := keys[expired.kid]; ok || len(keys) != 2 {
+		t.Fatalf("JWKS() has %d keys, want the 2 unexpired ones", len(keys))
+	}
+
+	tests := []struct {
+		name string
+		kid  string
+		want models.JWK
+	}{
+		{name: "EC", kid: ec.kid, want: models.JWK{Kty: "EC", Kid: ec.kid, Alg: "ES256", Use: "sig", Crv: "P-256"}},
+		{name: "RSA", kid: rs.kid, want: models.JWK{Kty: "RSA", Kid: rs.kid, Alg: "RS256", Use: "sig", E: "AQAB"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := keys[test.kid]
+			coordinates := []string{got.X, got.Y}
+			if test.want.Kty == "EC" {
+				for _, coordinate := range coordinates {
+					if raw, err := base64.RawURLEncoding.DecodeString(coordinate); err != nil || len(raw) != 32 {
+						t.Errorf("EC coordinate %q is not 32 bytes of base64url", coordinate)
+					}
+				}
+				got.X, got.Y = "", ""
+			} else {
+				if raw, err := base64.RawURLEncoding.DecodeString(got.N); err != nil || len(raw) != 256 {
+					t.Errorf("RSA modulus is not 256 bytes of base64url")
+				}
+				got.N = ""
+			}
+			if got != test.want {
+				t.Errorf("JWK = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
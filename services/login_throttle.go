@@ -0,0 +1,161 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/apeli23/infinity/database"
+	"github.com/apeli23/infinity/models"
+	"github.com/apeli23/infinity/utils"
+)
+
+var ErrLoginThrottled = errors.New("too many failed logins, try again later")
+
+// loginPolicy is how many failures a throttle key gets for free before every further attempt is delayed,
+// and how many lock it out for LOGIN_LOCKOUT.
+type loginPolicy struct {
+	free int
+	max  int
+}
+
+// IP addresses get more room than emails since offices and carriers put many partners behind one address.
+func loginPolicyFor(key string) loginPolicy {
+	if strings.HasPrefix(key, "ip:") {
+		return loginPolicy{
+			free: utils.GetEnvInt("LOGIN_IP_FREE_ATTEMPTS", 20),
+			max:  utils.GetEnvInt("LOGIN_IP_MAX_FAILURES", 100),
+		}
+	}
+	return loginPolicy{
+		free: utils.GetEnvInt("LOGIN_FREE_ATTEMPTS", 3),
+		max:  utils.GetEnvInt("LOGIN_MAX_FAILURES", 10),
+	}
+}
+
+func loginThrottleKeys(email, ip string) []string {
+	keys := []string{}
+	if email = strings.ToLower(strings.TrimSpace(email)); email != "" {
+		keys = append(keys, "email:"+email)
+	}
+	if ip != "" {
+		keys = append(keys, "ip:"+ip)
+	}
+	return keys
+}
+
+// loginDelay is the time to wait after the last failure: LOGIN_DELAY_BASE (1s) doubling with every failure
+// past the free ones, capped at LOGIN_DELAY_MAX (1m).
+func loginDelay(failures int, policy loginPolicy) time.Duration {
+	if failures < policy.free {
+		return 0
+	}
+	delay := utils.GetEnvDuration("LOGIN_DELAY_BASE", time.Second)
+	max := utils.GetEnvDuration("LOGIN_DELAY_MAX", time.Minute)
+	for i := policy.free; i < failures && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
+
+// CheckLogin returns ErrLoginThrottled and how long to wait when the email or the IP address is locked out or
+// has to wait before its next attempt. Otherwise it counts the attempt as a failure against both, locking out the
+// ones that reach their maximum, until RecordLoginSuccess takes it back. It is meant to run before the password is
+// checked: the throttle rows are locked while they are checked and counted, so concurrent attempts are counted one
+// after the other and cannot all get past the throttle. Failures older than LOGIN_FAILURE_WINDOW (15m) are forgotten.
+func CheckLogin(email, ip string) (retryAfter time.Duration, err error) {
+	now := time.Now()
+	window := utils.GetEnvDuration("LOGIN_FAILURE_WINDOW", 15*time.Minute)
+	lockout := utils.GetEnvDuration("LOGIN_LOCKOUT", 15*time.Minute)
+	keys := loginThrottleKeys(email, ip)
+
+	err = database.Db.Transaction(func(tx *gorm.DB) error {
+		for _, key := range keys {
+			if err := tx.Debug().Exec(`INSERT INTO login_throttles (key, failures, last_failure_at) VALUES (?, 0, ?)
+				ON CONFLICT (key) DO NOTHING`, key, now).Error; err != nil {
+				return err
+			}
+		}
+		throttles := []models.LoginThrottle{}
+		if err := tx.Debug().Table("login_throttles").Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("key IN ?", keys).Order("key").Find(&throttles).Error; err != nil {
+			return err
+		}
+
+		for _, throttle := range throttles {
+			wait := time.Duration(0)
+			if throttle.LockedUntil != nil {
+				wait = throttle.LockedUntil.Sub(now)
+			}
+			if now.Sub(throttle.LastFailureAt) < window {
+				if delayed := throttle.LastFailureAt.Add(loginDelay(throttle.Failures, loginPolicyFor(throttle.Key))).Sub(now); delayed > wait {
+					wait = delayed
+				}
+			}
+			if wait > retryAfter {
+				retryAfter = wait
+			}
+		}
+		if retryAfter > 0 {
+			return ErrLoginThrottled
+		}
+
+		for _, throttle := range throttles {
+			update := map[string]interface{}{"failures": throttle.Failures + 1, "last_failure_at": now}
+			if now.Sub(throttle.LastFailureAt) >= window {
+				update["failures"], update["locked_until"] = 1, nil
+			}
+			if update["failures"].(int) >= loginPolicyFor(throttle.Key).max {
+				update["locked_until"] = now.Add(lockout)
+			}
+			if err := tx.Debug().Table("login_throttles").Where("key = ?", throttle.Key).Updates(update).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return
+}
+
+// RecordLoginFailure keeps an audit record of a failed login, CheckLogin already counted it.
+func RecordLoginFailure(email, ip, endpoint, reason string) error {
+	attempt := models.LoginAttempt{
+		Email:     strings.ToLower(strings.TrimSpace(email)),
+		IP:        ip,
+		Endpoint:  endpoint,
+		Reason:    reason,
+		CreatedAt: time.Now(),
+	}
+	return database.Db.Debug().Table("login_attempts").Create(&attempt).Error
+}
+
+// RecordLoginSuccess clears the failures of the email and takes back the attempt CheckLogin counted against the
+// IP address. The IP address keeps its other failures so that one valid account does not reset the throttle of
+// an address trying many others.
+func RecordLoginSuccess(email, ip string) error {
+	return database.Db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Debug().Table("login_throttles").Where("key IN ?", loginThrottleKeys(email, "")).Delete(&models.LoginThrottle{}).Error; err != nil {
+			return err
+		}
+		for _, key := range loginThrottleKeys("", ip) {
+			if err := tx.Debug().Exec(`UPDATE login_throttles SET failures = GREATEST(failures - 1, 0),
+					locked_until = CASE WHEN failures - 1 < ? THEN NULL ELSE locked_until END
+				WHERE key = ?`, loginPolicyFor(key).max, key).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// UnlockLogin lifts the lockout and clears the failures of the email and/or IP address.
+func UnlockLogin(unlock models.LoginUnlock) (cleared int64, err error) {
+	result := database.Db.Debug().Table("login_throttles").Where("key IN ?", loginThrottleKeys(unlock.Email, unlock.IP)).Delete(&models.LoginThrottle{})
+	return result.RowsAffected, result.Error
+}
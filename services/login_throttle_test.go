@@ -0,0 +1,71 @@
+package services
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLoginThrottleKeys(t *testing.T) {
+	tests := []struct {
+		name  string
+		email string
+		ip    string
+		want  []string
+	}{
+		{name: "email and ip", email: "Ops@Partner.com ", ip: "10.0.0.1", want: []string{"email:ops@partner.com", "ip:10.0.0.1"}},
+		{name: "no email", email: "  ", ip: "10.0.0.1", want: []string{"ip:10.0.0.1"}},
+		{name: "no ip", email: "ops@partner.com", want: []string{"email:ops@partner.com"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := loginThrottleKeys(test.email, test.ip); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("loginThrottleKeys() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestLoginPolicyFor(t *testing.T) {
+	tests := []struct {
+		key  string
+		want loginPolicy
+	}{
+		{key: "email:ops@partner.com", want: loginPolicy{free: 3, max: 10}},
+		{key: "ip:10.0.0.1", want: loginPolicy{free: 20, max: 100}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.key, func(t *testing.T) {
+			if got := loginPolicyFor(test.key); got != test.want {
+				t.Errorf("loginPolicyFor(%q) = %+v, want %+v", test.key, got, test.want)
+			}
+		})
+	}
+}
+
+func TestLoginDelay(t *testing.T) {
+	policy := loginPolicy{free: 3, max: 10}
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{failures: 0, want: 0},
+		{failures: 2, want: 0},
+		{failures: 3, want: time.Second},
+		{failures: 4, want: 2 * time.Second},
+		{failures: 6, want: 8 * time.Second},
+		{failures: 9, want: time.Minute},
+		{failures: 50, want: time.Minute},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%d failures", test.failures), func(t *testing.T) {
+			if got := loginDelay(test.failures, policy); got != test.want {
+				t.Errorf("loginDelay(%d) = %v, want %v", test.failures, got, test.want)
+			}
+		})
+	}
+}
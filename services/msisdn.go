@@ -0,0 +1,165 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/apeli23/infinity/database"
+	"github.com/apeli23/infinity/models"
+	"github.com/apeli23/infinity/msisdn"
+)
+
+// msisdnCountries are the countries of the configured operators, the first operator's country being the one
+// national numbers are read in.
+var msisdnCountries = operatorCountries()
+
+func operatorCountries() []string {
+	countries := []string{}
+	seen := map[string]bool{}
+	for _, operator := range operators {
+		if _, err := msisdn.CountryByCode(operator.CountryCode); err != nil {
+			panic(fmt.Errorf("operator %s: %w", operator.Code, err))
+		}
+		if !seen[operator.CountryCode] {
+			seen[operator.CountryCode] = true
+			countries = append(countries, operator.CountryCode)
+		}
+	}
+	return countries
+}
+
+// NormalizeMsisdn validates the MSISDN and returns it in E.164 digits, the form subscriptions are stored under
+// and the SDP is sent.
+func NormalizeMsisdn(number string) (string, error) {
+	return msisdn.Normalize(number, msisdnCountries...)
+}
+
+// NormalizeCallbackMsisdn rewrites the Msisdn item of an SDP notification in E.164 digits, so partners are
+// forwarded the number they subscribed.
+func NormalizeCallbackMsisdn(notification *models.Callback) error {
+	for i, data := range notification.RequestParam.Data {
+		if data.Name != "Msisdn" {
+			continue
+		}
+		number, err := data.Text()
+		if err != nil {
+			return err
+		}
+		normalized, err := NormalizeMsisdn(number)
+		if err != nil {
+			return err
+		}
+		notification.RequestParam.Data[i].Value = normalized
+	}
+	return nil
+}
+
+// StartMsisdnNormalization brings the subscriptions stored before MSISDNs were normalised to E.164 digits in the
+// background.
+func StartMsisdnNormalization() {
+	go func() {
+		if err := NormalizeStoredMsisdns(); err != nil {
+			logrus.Error(err)
+		}
+	}()
+}
+
+// NormalizeStoredMsisdns rewrites the MSISDNs of subscriptions that are not in E.164 digits of a configured country.
+// A subscription whose normalised number is already subscribed to the plan is merged into that subscription; numbers
+// that are not valid are left as they are and logged.
+func NormalizeStoredMsisdns() error {
+	canonical := []string{}
+	args := []interface{}{}
+	for _, code := range msisdnCountries {
+		country, _ := msisdn.CountryByCode(code)
+		canonical = append(canonical, "msisdn LIKE ?")
+		args = append(args, country.CallingCode+"%")
+	}
+
+	stored := []models.Subscription{}
+	err := database.Db.Table("subscriptions").Select("id, plan_id, msisdn").
+		Where(fmt.Sprintf("msisdn !~ '^[0-9]+$' OR NOT (%s)", strings.Join(canonical, " OR ")), args...).
+		Order("id").Find(&stored).Error
+	if err != nil {
+		return err
+	}
+
+	for _, sub := range stored {
+		normalized, err := NormalizeMsisdn(sub.MSISDN)
+		if err != nil {
+			logrus.Warnf("MSISDN NORMALIZATION | SUBSCRIPTION : %d | %v", sub.ID, err)
+			continue
+		}
+		if normalized == sub.MSISDN {
+			continue
+		}
+		if err := normalizeSubscriptionMsisdn(sub, normalized); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// normalizeSubscriptionMsisdn moves the subscription to its normalised number. When the plan already has a
+// subscription under that number, the one updated last is kept as it holds the latest state the SDP reported,
+// and the events, transactions and external_id of the other are moved to it, the external_id as an alias.
+func normalizeSubscriptionMsisdn(sub models.Subscription, normalized string) error {
+	return database.Db.Transaction(func(tx *gorm.DB) error {
+		// the lock subscription transitions take on the plan and number
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", sub.PlanID+"|"+normalized).Error; err != nil {
+			return err
+		}
+		current := models.Subscription{}
+		err := tx.Table("subscriptions").Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ? AND msisdn = ?", sub.ID, sub.MSISDN).First(&current).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// normalised or merged by another instance
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		existing := models.Subscription{}
+		err = tx.Table("subscriptions").Clauses(clause.Locking{Strength: "UPDATE"}).Where("plan_id = ? AND msisdn = ?", sub.PlanID, normalized).First(&existing).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return tx.Table("subscriptions").Where("id = ?", current.ID).Update("msisdn", normalized).Error
+		}
+		if err != nil {
+			return err
+		}
+
+		kept, merged := existing, current
+		if current.UpdatedAt.After(existing.UpdatedAt) {
+			kept, merged = current, existing
+		}
+		logrus.Warnf("MSISDN NORMALIZATION | PLAN : %s | MSISDN : %s | MERGED SUBSCRIPTION %d (%s) INTO %d (%s)",
+			sub.PlanID, normalized, merged.ID, merged.Status, kept.ID, kept.Status)
+		for _, table := range []string{"subscription_events", "transactions", "subscription_aliases"} {
+			if err := tx.Table(table).Where("subscription_id = ?", merged.ID).Update("subscription_id", kept.ID).Error; err != nil {
+				return err
+			}
+		}
+		// the SDP and the partner still refer to the merged subscription by its external_id
+		if merged.ExternalID != "" && merged.ExternalID != kept.ExternalID {
+			alias := models.SubscriptionAlias{
+				ExternalID:     merged.ExternalID,
+				PlanID:         merged.PlanID,
+				SubscriptionID: kept.ID,
+				CreatedAt:      time.Now(),
+			}
+			if err := tx.Table("subscription_aliases").Clauses(clause.OnConflict{DoNothing: true}).Create(&alias).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Table("subscriptions").Where("id = ?", merged.ID).Delete(&models.Subscription{}).Error; err != nil {
+			return err
+		}
+		return tx.Table("subscriptions").Where("id = ?", kept.ID).Update("msisdn", normalized).Error
+	})
+}
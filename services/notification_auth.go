@@ -0,0 +1,161 @@
+package services
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+
+	"github.com/apeli23/infinity/database"
+	"github.com/apeli23/infinity/utils"
+	"github.com/apeli23/infinity/webhook"
+)
+
+// Reasons an inbound SDP notification is rejected for. They double as keys of the rejection counters.
+var (
+	ErrNotificationSource    = errors.New("source ip not allowed")
+	ErrNotificationSecret    = errors.New("invalid shared secret")
+	ErrNotificationSignature = errors.New("invalid signature")
+	ErrNotificationBody      = errors.New("unreadable notification body")
+	ErrNotificationReplay    = errors.New("notification already received")
+	ErrNotificationData      = errors.New("invalid notification data")
+)
+
+var (
+	rejectionsMu sync.Mutex
+	rejections   = map[string]uint64{}
+)
+
+// CountNotificationRejection increments the counter for reason and returns the new total.
+func CountNotificationRejection(reason error) uint64 {
+	rejectionsMu.Lock()
+	defer rejectionsMu.Unlock()
+	rejections[reason.Error()]++
+	return rejections[reason.Error()]
+}
+
+// NotificationRejections returns a snapshot of the rejected notification counters since the process started.
+func NotificationRejections() map[string]uint64 {
+	rejectionsMu.Lock()
+	defer rejectionsMu.Unlock()
+	snapshot := make(map[string]uint64, len(rejections))
+	for reason, count := range rejections {
+		snapshot[reason] = count
+	}
+	return snapshot
+}
+
+// notificationSourceAllowed checks ip against SDP_NOTIFICATION_ALLOWED_CIDRS, a comma separated list of CIDRs.
+// Every source is allowed when the list is empty.
+func notificationSourceAllowed(ip string) bool {
+	allowed := strings.TrimSpace(os.Getenv("SDP_NOTIFICATION_ALLOWED_CIDRS"))
+	if allowed == "" {
+		return true
+	}
+
+	source := net.ParseIP(ip)
+	if source == nil {
+		return false
+	}
+	for _, cidr := range strings.Split(allowed, ",") {
+		_, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			continue
+		}
+		if network.Contains(source) {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifyNotification runs the checks configured for inbound SDP notifications:
+//   - SDP_NOTIFICATION_ALLOWED_CIDRS: the caller IP must be in one of the CIDRs
+//   - SDP_NOTIFICATION_SECRET: the header named by SDP_NOTIFICATION_SECRET_HEADER (X-Notification-Secret) must carry the secret
+//   - SDP_NOTIFICATION_HMAC_SECRET: X-Signature must be the webhook signature of the body, see package webhook
+//
+// and finally rejects a Callback.RequestId already processed within SDP_NOTIFICATION_REPLAY_WINDOW (24h).
+func VerifyNotification(ip string, header http.Header, body []byte) error {
+	if !notificationSourceAllowed(ip) {
+		return ErrNotificationSource
+	}
+
+	if secret := os.Getenv("SDP_NOTIFICATION_SECRET"); secret != "" {
+		headerName := os.Getenv("SDP_NOTIFICATION_SECRET_HEADER")
+		if headerName == "" {
+			headerName = "X-Notification-Secret"
+		}
+		if subtle.ConstantTimeCompare([]byte(header.Get(headerName)), []byte(secret)) != 1 {
+			return ErrNotificationSecret
+		}
+	}
+
+	if secret := os.Getenv("SDP_NOTIFICATION_HMAC_SECRET"); secret != "" {
+		tolerance := utils.GetEnvDuration("SDP_NOTIFICATION_SIGNATURE_TOLERANCE", 5*time.Minute)
+		if err := webhook.Verify(header.Get(webhook.SignatureHeader), header.Get(webhook.TimestampHeader), body, tolerance, secret); err != nil {
+			return ErrNotificationSignature
+		}
+	}
+
+	notification := struct {
+		RequestId string `json:"requestId"`
+	}{}
+	if err := json.Unmarshal(body, &notification); err != nil {
+		return ErrNotificationBody
+	}
+	if notification.RequestId == "" {
+		return nil
+	}
+
+	// the notification is only recorded once it is applied, see recordNotification. Failing to look it up lets
+	// the handler decide, since recording it again fails the same way for replays.
+	seen, err := notificationProcessed(notification.RequestId)
+	if err != nil {
+		logrus.Error(err)
+		return nil
+	}
+	if seen {
+		return ErrNotificationReplay
+	}
+	return nil
+}
+
+// notificationReplayCutoff is when notifications stop counting as replays, SDP_NOTIFICATION_REPLAY_WINDOW (24h) ago.
+func notificationReplayCutoff() time.Time {
+	return time.Now().Add(-utils.GetEnvDuration("SDP_NOTIFICATION_REPLAY_WINDOW", 24*time.Hour))
+}
+
+// notificationProcessed reports whether the notification with requestId was applied within the replay window.
+func notificationProcessed(requestId string) (bool, error) {
+	var count int64
+	err := database.Db.Table("sdp_notifications").
+		Where("request_id = ? AND processed_at > ?", requestId, notificationReplayCutoff()).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// recordNotification marks the notification with requestId processed inside tx, the transaction applying it, so
+// a notification is applied at most once while one whose processing failed can still be delivered again.
+// It returns ErrNotificationReplay when the notification was applied already.
+func recordNotification(tx *gorm.DB, requestId string) error {
+	// a record older than the replay window no longer blocks the notification
+	if err := tx.Exec("DELETE FROM sdp_notifications WHERE request_id = ? AND processed_at <= ?", requestId, notificationReplayCutoff()).Error; err != nil {
+		return err
+	}
+	result := tx.Exec("INSERT INTO sdp_notifications (request_id) VALUES (?) ON CONFLICT (request_id) DO NOTHING", requestId)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotificationReplay
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/apeli23/infinity/webhook"
+)
+
+func TestNotificationSourceAllowed(t *testing.T) {
+	tests := []struct {
+		name  string
+		cidrs string
+		ip    string
+		want  bool
+	}{
+		{name: "no allow-list", cidrs: "", ip: "203.0.113.9", want: true},
+		{name: "in range", cidrs: "196.201.214.0/24", ip: "196.201.214.10", want: true},
+		{name: "second range", cidrs: "196.201.214.0/24, 10.0.0.0/8", ip: "10.1.2.3", want: true},
+		{name: "outside", cidrs: "196.201.214.0/24", ip: "196.201.215.10", want: false},
+		{name: "invalid entries skipped", cidrs: "sdp, 10.0.0.0/8", ip: "10.1.2.3", want: true},
+		{name: "ipv6", cidrs: "2001:db8::/32", ip: "2001:db8::1", want: true},
+		{name: "not an ip", cidrs: "10.0.0.0/8", ip: "localhost", want: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("SDP_NOTIFICATION_ALLOWED_CIDRS", test.cidrs)
+			if got := notificationSourceAllowed(test.ip); got != test.want {
+				t.Errorf("notificationSourceAllowed(%q) = %v, want %v", test.ip, got, test.want)
+			}
+		})
+	}
+}
+
+func TestVerifyNotification(t *testing.T) {
+	body := []byte(`{"requestParam": {"data": []}}`)
+	now := time.Now().Unix()
+	signed := func(secret string, timestamp int64, body []byte) http.Header {
+		return http.Header{
+			webhook.SignatureHeader: {webhook.Header([]string{secret}, timestamp, body)},
+			webhook.TimestampHeader: {fmt.Sprintf("%d", timestamp)},
+		}
+	}
+	tests := []struct {
+		name         string
+		cidrs        string
+		secret       string
+		secretHeader string
+		hmacSecret   string
+		ip           string
+		header       http.Header
+		body         []byte
+		want         error
+	}{
+		{name: "no checks configured", ip: "203.0.113.9", header: http.Header{}, body: body},
+		{name: "source refused", cidrs: "10.0.0.0/8", ip: "203.0.113.9", header: http.Header{}, body: body, want: ErrNotificationSource},
+		{name: "shared secret", secret: "s3cret", header: http.Header{"X-Notification-Secret": {"s3cret"}}, body: body},
+		{name: "shared secret in custom header", secret: "s3cret", secretHeader: "X-Sdp-Token", header: http.Header{"X-Sdp-Token": {"s3cret"}}, body: body},
+		{name: "wrong shared secret", secret: "s3cret", header: http.Header{"X-Notification-Secret": {"guess"}}, body: body, want: ErrNotificationSecret},
+		{name: "missing shared secret", secret: "s3cret", header: http.Header{}, body: body, want: ErrNotificationSecret},
+		{name: "signed", hmacSecret: "hmac", header: signed("hmac", now, body), body: body},
+		{name: "signed with another secret", hmacSecret: "hmac", header: signed("other", now, body), body: body, want: ErrNotificationSignature},
+		{name: "signature replayed", hmacSecret: "hmac", header: signed("hmac", now-3600, body), body: body, want: ErrNotificationSignature},
+		{name: "unsigned", hmacSecret: "hmac", header: http.Header{}, body: body, want: ErrNotificationSignature},
+		{name: "not json", header: http.Header{}, body: []byte("requestId=1"), want: ErrNotificationBody},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("SDP_NOTIFICATION_ALLOWED_CIDRS", test.cidrs)
+			t.Setenv("SDP_NOTIFICATION_SECRET", test.secret)
+			t.Setenv("SDP_NOTIFICATION_SECRET_HEADER", test.secretHeader)
+			t.Setenv("SDP_NOTIFICATION_HMAC_SECRET", test.hmacSecret)
+			if err := VerifyNotification(test.ip, test.header, test.body); !errors.Is(err, test.want) {
+				t.Errorf("VerifyNotification() = %v, want %v", err, test.want)
+			}
+		})
+	}
+}
+
+func TestCountNotificationRejection(t *testing.T) {
+	before := NotificationRejections()[ErrNotificationSource.Error()]
+	CountNotificationRejection(ErrNotificationSource)
+	if got := CountNotificationRejection(ErrNotificationSource); got != before+2 {
+		t.Errorf("CountNotificationRejection() = %d, want %d", got, before+2)
+	}
+	if got := NotificationRejections()[ErrNotificationSource.Error()]; got != before+2 {
+		t.Errorf("NotificationRejections() = %d, want %d", got, before+2)
+	}
+}
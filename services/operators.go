@@ -0,0 +1,125 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/apeli23/infinity/database"
+	"github.com/apeli23/infinity/models"
+)
+
+var (
+	ErrUnknownOperator  = errors.New("unknown operator")
+	ErrNoOperator       = errors.New("no operator serves this msisdn")
+	ErrOperatorMismatch = errors.New("msisdn does not belong to the plan's operator")
+)
+
+// operators are the networks we can reach, loaded once at start up.
+var operators = loadOperators()
+
+// loadOperators reads the operators from the JSON array in the file at OPERATORS_CONFIG. Environment variables
+// in the file are expanded so credentials can stay in the environment, e.g. "he_password": "${SAF_HE_PASSWORD}".
+// Without OPERATORS_CONFIG the single Safaricom operator is configured from the HE_*, SDP_*, CPID and X_API_KEY
+// variables, serving every MSISDN as before operators existed.
+func loadOperators() []models.Operator {
+	path := os.Getenv("OPERATORS_CONFIG")
+	if path == "" {
+		return []models.Operator{{
+			Code:             "safaricom",
+			Name:             "Safaricom",
+			CountryCode:      "KE",
+			BaseURL:          os.Getenv("HE_BASE_URL"),
+			HeAuthURL:        os.Getenv("HE_AUTH_URL"),
+			HeUsername:       os.Getenv("HE_USERNAME"),
+			HePassword:       os.Getenv("HE_PASSWORD"),
+			SdpAuthURL:       os.Getenv("SDP_AUTH_URL"),
+			SdpUsername:      os.Getenv("SDP_USERNAME"),
+			SdpPassword:      os.Getenv("SDP_PASSWORD"),
+			CPID:             os.Getenv("CPID"),
+			APIKey:           os.Getenv("X_API_KEY"),
+			ActDeactCallback: os.Getenv("ACT_DEACT_NOTIFICATION"),
+			ChargeCallback:   os.Getenv("CHARGE_CALLBACK"),
+		}}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		panic(fmt.Errorf("OPERATORS_CONFIG: %w", err))
+	}
+	configured := []models.Operator{}
+	if err := json.Unmarshal([]byte(os.ExpandEnv(string(data))), &configured); err != nil {
+		panic(fmt.Errorf("OPERATORS_CONFIG: %w", err))
+	}
+	for _, operator := range configured {
+		logrus.Infof("OPERATOR | CODE : %s | COUNTRY : %s | PREFIXES : %s", operator.Code, operator.CountryCode, strings.Join(operator.Prefixes, ","))
+	}
+	return configured
+}
+
+// Operators returns the configured operators.
+func Operators() []models.Operator {
+	return operators
+}
+
+// OperatorByCode returns the operator configured under code.
+func OperatorByCode(code string) (models.Operator, error) {
+	for _, operator := range operators {
+		if operator.Code == code {
+			return operator, nil
+		}
+	}
+	return models.Operator{}, fmt.Errorf("%w: %s", ErrUnknownOperator, code)
+}
+
+// OperatorForMsisdn returns the operator with the longest prefix matching the MSISDN, or the catch-all operator
+// (one without prefixes) when none matches.
+func OperatorForMsisdn(msisdn string) (operator models.Operator, err error) {
+	msisdn = strings.TrimPrefix(msisdn, "+")
+	longest := -1
+	for _, candidate := range operators {
+		if len(candidate.Prefixes) == 0 && longest < 0 {
+			operator, longest = candidate, 0
+		}
+		for _, prefix := range candidate.Prefixes {
+			if strings.HasPrefix(msisdn, prefix) && len(prefix) > longest {
+				operator, longest = candidate, len(prefix)
+			}
+		}
+	}
+	if longest < 0 {
+		err = ErrNoOperator
+	}
+	return
+}
+
+// ResolveOperator returns the operator a request for msisdn on a plan of planOperator goes to. Plans bound to an
+// operator only accept its subscribers, other plans go wherever the MSISDN routes.
+func ResolveOperator(msisdn, planOperator string) (models.Operator, error) {
+	routed, err := OperatorForMsisdn(msisdn)
+	if planOperator == "" {
+		return routed, err
+	}
+
+	operator, err := OperatorByCode(planOperator)
+	if err != nil {
+		return operator, err
+	}
+	if routed.Code != operator.Code {
+		return operator, ErrOperatorMismatch
+	}
+	return operator, nil
+}
+
+// requestOperator returns the operator an SDP request goes to, from its plan (offer code) and MSISDN.
+func requestOperator(request *models.HeRequest) (models.Operator, error) {
+	plan := models.Plan{}
+	if err := database.Db.Debug().Table("plans").Where("id = ?", request.OfferCode).First(&plan).Error; err != nil {
+		return models.Operator{}, err
+	}
+	return ResolveOperator(request.Msisdn, plan.Operator)
+}
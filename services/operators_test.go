@@ -0,0 +1,118 @@
+package services
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/apeli23/infinity/models"
+)
+
+// useOperators replaces the configured operators for the test.
+func useOperators(t *testing.T, configured ...models.Operator) {
+	previous := operators
+	operators = configured
+	t.Cleanup(func() { operators = previous })
+}
+
+func TestOperatorForMsisdn(t *testing.T) {
+	safaricom := models.Operator{Code: "safaricom", Prefixes: []string{"2547", "25411"}}
+	airtel := models.Operator{Code: "airtel", Prefixes: []string{"25473", "25410"}}
+	mtn := models.Operator{Code: "mtn"}
+
+	tests := []struct {
+		name      string
+		operators []models.Operator
+		msisdn    string
+		want      string
+		wantErr   error
+	}{
+		{name: "prefix", operators: []models.Operator{safaricom, airtel}, msisdn: "254712345678", want: "safaricom"},
+		{name: "longest prefix wins", operators: []models.Operator{safaricom, airtel}, msisdn: "254733123456", want: "airtel"},
+		{name: "plus sign", operators: []models.Operator{safaricom, airtel}, msisdn: "+254110123456", want: "safaricom"},
+		{name: "no match", operators: []models.Operator{safaricom, airtel}, msisdn: "256772123456", wantErr: ErrNoOperator},
+		{name: "catch-all", operators: []models.Operator{safaricom, mtn}, msisdn: "256772123456", want: "mtn"},
+		{name: "prefix beats catch-all", operators: []models.Operator{mtn, safaricom}, msisdn: "254712345678", want: "safaricom"},
+		{name: "none configured", msisdn: "254712345678", wantErr: ErrNoOperator},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useOperators(t, test.operators...)
+			operator, err := OperatorForMsisdn(test.msisdn)
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("OperatorForMsisdn() error = %v, want %v", err, test.wantErr)
+			}
+			if operator.Code != test.want {
+				t.Errorf("OperatorForMsisdn() = %q, want %q", operator.Code, test.want)
+			}
+		})
+	}
+}
+
+func TestResolveOperator(t *testing.T) {
+	useOperators(t,
+		models.Operator{Code: "safaricom", Prefixes: []string{"2547"}},
+		models.Operator{Code: "airtel", Prefixes: []string{"25473"}},
+	)
+
+	tests := []struct {
+		name         string
+		msisdn       string
+		planOperator string
+		want         string
+		wantErr      error
+	}{
+		{name: "routed", msisdn: "254712345678", want: "safaricom"},
+		{name: "plan operator", msisdn: "254733123456", planOperator: "airtel", want: "airtel"},
+		{name: "subscriber of another operator", msisdn: "254712345678", planOperator: "airtel", want: "airtel", wantErr: ErrOperatorMismatch},
+		{name: "unknown plan operator", msisdn: "254712345678", planOperator: "telkom", wantErr: ErrUnknownOperator},
+		{name: "unrouted msisdn", msisdn: "256772123456", wantErr: ErrNoOperator},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			operator, err := ResolveOperator(test.msisdn, test.planOperator)
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("ResolveOperator() error = %v, want %v", err, test.wantErr)
+			}
+			if operator.Code != test.want {
+				t.Errorf("ResolveOperator() = %q, want %q", operator.Code, test.want)
+			}
+		})
+	}
+}
+
+func TestLoadOperators(t *testing.T) {
+	t.Setenv("HE_USERNAME", "he-user")
+	t.Setenv("AIRTEL_SDP_PASSWORD", "from-env")
+	config := filepath.Join(t.TempDir(), "operators.json")
+	if err := os.WriteFile(config, []byte(`[{"code": "airtel", "country_code": "KE", "prefixes": ["25473"], "sdp_password": "${AIRTEL_SDP_PASSWORD}"}]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		config string
+		want   models.Operator
+	}{
+		{name: "from the environment", want: models.Operator{Code: "safaricom", Name: "Safaricom", CountryCode: "KE", HeUsername: "he-user"}},
+		{name: "from the config file", config: config, want: models.Operator{Code: "airtel", CountryCode: "KE", Prefixes: []string{"25473"}, SdpPassword: "from-env"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("OPERATORS_CONFIG", test.config)
+			loaded := loadOperators()
+			if len(loaded) != 1 {
+				t.Fatalf("loadOperators() returned %d operators, want 1", len(loaded))
+			}
+			got := loaded[0]
+			if got.Code != test.want.Code || got.Name != test.want.Name || got.CountryCode != test.want.CountryCode ||
+				got.HeUsername != test.want.HeUsername || got.SdpPassword != test.want.SdpPassword || len(got.Prefixes) != len(test.want.Prefixes) {
+				t.Errorf("loadOperators() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
@@ -1,22 +1,129 @@
 package services
 
 import (
+	"errors"
+	"fmt"
+	"time"
+
 	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"github.com/apeli23/infinity/database"
 	"github.com/apeli23/infinity/models"
+	"github.com/apeli23/infinity/utils"
 )
 
-func CreatePartner(partner *models.Partner) (err error) {
+var ErrInvalidResetToken = errors.New("invalid or expired reset token")
+
+// CreatePartner saves the partner with a generated secret and a first webhook secret, so callbacks are signed from
+// the start. Both are returned to be shown once in the create response; the secret is never logged or stored in clear.
+func CreatePartner(partner *models.Partner) (secret, webhookSecret string, err error) {
 
-	password := GeneratePassword()
-	logrus.Info(password, partner.Email)
-	partner.Secret, _ = HashPassword(password)
+	if secret, err = GenerateSecret(24); err != nil {
+		return
+	}
+	if partner.Secret, err = HashPassword(secret); err != nil {
+		return
+	}
+	if webhookSecret, err = GenerateSecret(32); err != nil {
+		return
+	}
+	partner.WebhookSecret = webhookSecret
+	partner.PreviousWebhookSecret = ""
+	partner.PreviousWebhookSecretExpiresAt = nil
 
 	if err = database.Db.Debug().Table("partners").Save(partner).Error; err != nil {
 		logrus.Error(err)
 		return
 	}
+	logrus.Infof("PARTNER CREATED | ID : %d | EMAIL : %s", partner.ID, partner.Email)
+
+	return
+}
+
+// setPartnerSecret replaces the partner's secret with a new generated one, consumes any pending reset token and
+// revokes the partner's refresh tokens so sessions opened with the old secret cannot be extended.
+func setPartnerSecret(tx *gorm.DB, partnerId uint) (secret string, err error) {
+	if secret, err = GenerateSecret(24); err != nil {
+		return
+	}
+	hash, err := HashPassword(secret)
+	if err != nil {
+		return
+	}
+
+	now := time.Now()
+	if err = tx.Debug().Table("partners").Where("id = ?", partnerId).Updates(map[string]interface{}{
+		"secret":                 hash,
+		"secret_rotated_at":      now,
+		"reset_token_hash":       "",
+		"reset_token_expires_at": nil,
+		"updated_at":             now,
+	}).Error; err != nil {
+		return
+	}
+	err = tx.Debug().Table("refresh_tokens").
+		Where("partner_id = ? AND user_id = '' AND revoked_at IS NULL", fmt.Sprintf("%d", partnerId)).
+		Update("revoked_at", now).Error
+	return
+}
+
+// RotatePartnerSecret issues a new secret for the partner, the old one stops working immediately.
+func RotatePartnerSecret(partnerId string) (secret string, err error) {
+	partner := models.Partner{}
+	if err = database.Db.Debug().Table("partners").Where("id = ?", partnerId).First(&partner).Error; err != nil {
+		return
+	}
+	err = database.Db.Transaction(func(tx *gorm.DB) (err error) {
+		secret, err = setPartnerSecret(tx, partner.ID)
+		return
+	})
+	if err == nil {
+		logrus.Infof("PARTNER SECRET ROTATED | ID : %d", partner.ID)
+	}
+	return
+}
+
+// IssuePartnerResetToken creates the one-time token an admin hands to a partner that lost its secret. It expires
+// after PARTNER_RESET_TOKEN_TTL (1h by default) and replaces any token issued before.
+func IssuePartnerResetToken(partnerId string) (token string, expiresAt time.Time, err error) {
+	if token, err = GenerateSecret(32); err != nil {
+		return
+	}
+	expiresAt = time.Now().Add(utils.GetEnvDuration("PARTNER_RESET_TOKEN_TTL", time.Hour))
+
+	result := database.Db.Debug().Table("partners").Where("id = ?", partnerId).Updates(map[string]interface{}{
+		"reset_token_hash":       HashToken(token),
+		"reset_token_expires_at": expiresAt,
+		"updated_at":             time.Now(),
+	})
+	if err = result.Error; err == nil && result.RowsAffected == 0 {
+		err = gorm.ErrRecordNotFound
+	}
+	return
+}
+
+// ResetPartnerSecret exchanges a reset token for a new secret.
+func ResetPartnerSecret(token string) (secret string, err error) {
+	err = database.Db.Transaction(func(tx *gorm.DB) error {
+		partner := models.Partner{}
+		if err := tx.Debug().Table("partners").
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("reset_token_hash = ?", HashToken(token)).
+			First(&partner).Error; err != nil {
+			return ErrInvalidResetToken
+		}
+		if partner.ResetTokenExpiresAt == nil || partner.ResetTokenExpiresAt.Before(time.Now()) {
+			return ErrInvalidResetToken
+		}
 
+		var err error
+		secret, err = setPartnerSecret(tx, partner.ID)
+		if err == nil {
+			logrus.Infof("PARTNER SECRET RESET | ID : %d", partner.ID)
+		}
+		return err
+	})
 	return
 }
 
@@ -31,10 +138,62 @@ func GetPartnerByEmail(email string) (partner models.Partner, err error) {
 
 func PartnerCheckPlanAccess(userId, planId string) (plan models.Plan) {
 	// plan := models.Plan{}
-	if err := database.Db.Debug().Table("plans").Where("id = ? AND partner_id = ?", planId, userId).First(&plan).Error; err != nil {
+	if err := database.Db.Debug().Table("plans").Where("id = ? AND partner_id = ? AND archived_at IS NULL", planId, userId).First(&plan).Error; err != nil {
 		logrus.Error(err)
 		return
 	}
 	return
 
-}
\ No newline at end of file
+}
+
+// RotateWebhookSecret issues a new callback signing secret for the partner. The current secret is kept as the
+// previous one for WEBHOOK_SECRET_OVERLAP (24h by default) so the partner can roll over without dropping callbacks.
+func RotateWebhookSecret(partnerId string) (secret string, previousExpiresAt *time.Time, err error) {
+	partner := models.Partner{}
+	if err = database.Db.Debug().Table("partners").Where("id = ?", partnerId).First(&partner).Error; err != nil {
+		return
+	}
+
+	if secret, err = GenerateSecret(32); err != nil {
+		return
+	}
+
+	update := map[string]interface{}{
+		"webhook_secret": secret,
+		"updated_at":     time.Now(),
+	}
+	if partner.WebhookSecret != "" {
+		expiresAt := time.Now().Add(utils.GetEnvDuration("WEBHOOK_SECRET_OVERLAP", 24*time.Hour))
+		previousExpiresAt = &expiresAt
+		update["previous_webhook_secret"] = partner.WebhookSecret
+		update["previous_webhook_secret_expires_at"] = expiresAt
+	}
+
+	err = database.Db.Debug().Table("partners").Where("id = ?", partner.ID).Updates(update).Error
+	return
+}
+
+// PartnerWebhookSecrets returns the secrets callbacks to the partner are currently signed with, newest first.
+func PartnerWebhookSecrets(partnerId uint) (secrets []string, err error) {
+	partner := models.Partner{}
+	if err = database.Db.Debug().Table("partners").Where("id = ?", partnerId).First(&partner).Error; err != nil {
+		return
+	}
+	if partner.WebhookSecret != "" {
+		secrets = append(secrets, partner.WebhookSecret)
+	}
+	if partner.PreviousWebhookSecret != "" && partner.PreviousWebhookSecretExpiresAt != nil &&
+		partner.PreviousWebhookSecretExpiresAt.After(time.Now()) {
+		secrets = append(secrets, partner.PreviousWebhookSecret)
+	}
+	return
+}
+
+// PlanPartnerID returns the partner that owns the plan (offer code).
+func PlanPartnerID(planId string) (partnerId uint, err error) {
+	plan := models.Plan{}
+	if err = database.Db.Debug().Table("plans").Where("id = ?", planId).First(&plan).Error; err != nil {
+		return
+	}
+	return plan.PartnerID, nil
+}
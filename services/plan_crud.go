@@ -0,0 +1,149 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/apeli23/infinity/database"
+	"github.com/apeli23/infinity/models"
+)
+
+var (
+	ErrForbidden    = errors.New("not allowed to manage this resource")
+	ErrPlanArchived = errors.New("plan is archived")
+	ErrPlanExists   = errors.New("a plan with this offer code already exists")
+	ErrNoPartner    = errors.New("partner is required for callers that do not act for a partner")
+)
+
+// CreatePlan stores a new plan. Partners create plans for themselves, admins may create them for any partner.
+func CreatePlan(callerId string, admin bool, request models.PlanRequest) (plan models.Plan, err error) {
+	ownerId := callerId
+	if request.PartnerID != 0 && fmt.Sprintf("%d", request.PartnerID) != callerId {
+		if !admin {
+			err = ErrForbidden
+			return
+		}
+		ownerId = fmt.Sprintf("%d", request.PartnerID)
+	}
+	// admins logged in without a partner have to name the one the plan belongs to
+	if ownerId == "" {
+		err = ErrNoPartner
+		return
+	}
+	partnerId, err := strconv.ParseUint(ownerId, 10, 64)
+	if err != nil {
+		return
+	}
+	if request.Operator != "" {
+		if _, err = OperatorByCode(request.Operator); err != nil {
+			return
+		}
+	}
+
+	plan = models.Plan{
+		ID:        request.OfferCode,
+		Name:      request.Name,
+		Amount:    request.Amount,
+		Cycle:     request.Cycle,
+		PartnerID: uint(partnerId),
+		Operator:  request.Operator,
+	}
+	result := database.Db.Debug().Table("plans").Clauses(clause.OnConflict{DoNothing: true}).Create(&plan)
+	if result.Error != nil {
+		err = result.Error
+		return
+	}
+	if result.RowsAffected == 0 {
+		err = ErrPlanExists
+	}
+	return
+}
+
+// ListPlans returns the partner's plans, archived ones only when asked for.
+func ListPlans(partnerId string, includeArchived bool) (plans []models.Plan, err error) {
+	plans = []models.Plan{}
+	query := database.Db.Debug().Table("plans").Where("partner_id = ?", partnerId)
+	if !includeArchived {
+		query = query.Where("archived_at IS NULL")
+	}
+	err = query.Order("created_at DESC").Find(&plans).Error
+	return
+}
+
+// managedPlan locks the plan for update and checks the caller owns it or is an admin.
+func managedPlan(tx *gorm.DB, callerId string, admin bool, planId string) (plan models.Plan, err error) {
+	if err = tx.Debug().Table("plans").Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", planId).First(&plan).Error; err != nil {
+		return
+	}
+	if fmt.Sprintf("%d", plan.PartnerID) != callerId && !admin {
+		err = ErrForbidden
+	}
+	return
+}
+
+// UpdatePlan applies the changes in update and records a price change audit entry when the cost changes.
+func UpdatePlan(callerId string, admin bool, planId string, update models.PlanUpdate) (plan models.Plan, err error) {
+	err = database.Db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if plan, err = managedPlan(tx, callerId, admin, planId); err != nil {
+			return err
+		}
+		if plan.ArchivedAt != nil {
+			return ErrPlanArchived
+		}
+
+		changes := map[string]interface{}{"updated_at": time.Now()}
+		if update.Name != nil {
+			changes["name"] = *update.Name
+		}
+		if update.Cycle != nil {
+			changes["frequency"] = *update.Cycle
+		}
+		if update.Amount != nil && *update.Amount != plan.Amount {
+			changes["cost"] = *update.Amount
+			audit := models.PlanPriceChange{
+				PlanID:    plan.ID,
+				OldAmount: plan.Amount,
+				NewAmount: *update.Amount,
+				ChangedBy: callerId,
+			}
+			if err := tx.Debug().Table("plan_price_changes").Create(&audit).Error; err != nil {
+				return err
+			}
+			logrus.Infof("PLAN PRICE CHANGE | PLAN : %s | OLD : %.2f | NEW : %.2f | BY : %s", plan.ID, plan.Amount, *update.Amount, callerId)
+		}
+
+		if err := tx.Debug().Table("plans").Where("id = ?", plan.ID).Updates(changes).Error; err != nil {
+			return err
+		}
+		return tx.Debug().Table("plans").Where("id = ?", plan.ID).First(&plan).Error
+	})
+	return
+}
+
+// ArchivePlan hides the plan from new activations and charges. Existing subscriptions and history are kept.
+func ArchivePlan(callerId string, admin bool, planId string) (plan models.Plan, err error) {
+	err = database.Db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if plan, err = managedPlan(tx, callerId, admin, planId); err != nil {
+			return err
+		}
+		if plan.ArchivedAt != nil {
+			return nil
+		}
+
+		now := time.Now()
+		plan.ArchivedAt = &now
+		return tx.Debug().Table("plans").Where("id = ?", plan.ID).Updates(map[string]interface{}{
+			"archived_at": now,
+			"updated_at":  now,
+		}).Error
+	})
+	return
+}
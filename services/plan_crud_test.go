@@ -0,0 +1,33 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/apeli23/infinity/models"
+)
+
+func TestCreatePlanOwner(t *testing.T) {
+	request := func(partnerId uint) models.PlanRequest {
+		return models.PlanRequest{OfferCode: "1029", Name: "Daily", Amount: 10, Cycle: models.CycleDaily, PartnerID: partnerId}
+	}
+	tests := []struct {
+		name     string
+		callerId string
+		admin    bool
+		request  models.PlanRequest
+		want     error
+	}{
+		{name: "partner for another partner", callerId: "1", request: request(2), want: ErrForbidden},
+		{name: "admin without partner", callerId: "", admin: true, request: request(0), want: ErrNoPartner},
+		{name: "caller without partner", callerId: "", request: request(0), want: ErrNoPartner},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := CreatePlan(test.callerId, test.admin, test.request); !errors.Is(err, test.want) {
+				t.Errorf("CreatePlan() error = %v, want %v", err, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm/clause"
+
+	"github.com/apeli23/infinity/database"
+	"github.com/apeli23/infinity/models"
+	"github.com/apeli23/infinity/utils"
+)
+
+var (
+	ErrRateLimited   = errors.New("rate limit exceeded")
+	ErrQuotaExceeded = errors.New("daily quota exceeded")
+)
+
+// rateLimiter holds this instance's token buckets, so the rate is enforced per instance while
+// daily quotas are counted in the database and shared by every instance.
+var rateLimiter = utils.NewRateLimiter()
+
+// StartRateLimiter starts purging the idle token buckets of this instance.
+func StartRateLimiter() {
+	rateLimiter.StartPurging()
+}
+
+func rateLimitCacheKey(partnerId, route string) string {
+	return fmt.Sprintf("RATE_LIMIT_%s_%s", partnerId, route)
+}
+
+// PartnerRateLimit returns the limits of the partner on route: its row for the route, else its "*" row, else
+// RATE_LIMIT_RPS (5), RATE_LIMIT_BURST (10) and RATE_LIMIT_DAILY_QUOTA (0, no quota). Limits are cached for a minute.
+func PartnerRateLimit(partnerId, route string) (limit models.RateLimit, err error) {
+	if cached, ok := utils.CacheInstance.Get(rateLimitCacheKey(partnerId, route)); ok {
+		return cached.(models.RateLimit), nil
+	}
+
+	limits := []models.RateLimit{}
+	if err = database.Db.Table("partner_rate_limits").
+		Where("partner_id = ? AND route IN ?", partnerId, []string{route, "*"}).
+		Order("route = '*'").Limit(1).
+		Find(&limits).Error; err != nil {
+		return
+	}
+	if len(limits) > 0 {
+		limit = limits[0]
+	} else {
+		limit = models.RateLimit{
+			Route:         route,
+			RatePerSecond: float64(utils.GetEnvInt("RATE_LIMIT_RPS", 5)),
+			Burst:         utils.GetEnvInt("RATE_LIMIT_BURST", 10),
+			DailyQuota:    utils.GetEnvInt("RATE_LIMIT_DAILY_QUOTA", 0),
+		}
+	}
+	utils.CacheInstance.Set(rateLimitCacheKey(partnerId, route), limit, time.Minute)
+	return
+}
+
+// consumeDailyQuota counts one call against today's (UTC) quota and returns the calls made so far,
+// or ErrQuotaExceeded without counting once the quota is used up.
+func consumeDailyQuota(partnerId, route string, quota int) (used int, err error) {
+	rows := []int{}
+	err = database.Db.Raw(`INSERT INTO partner_daily_usage (partner_id, route, day, count) VALUES (?, ?, ?, 1)
+		ON CONFLICT (partner_id, route, day) DO UPDATE SET count = partner_daily_usage.count + 1
+		WHERE partner_daily_usage.count < ?
+		RETURNING count`, partnerId, route, time.Now().UTC().Format("2006-01-02"), quota).Scan(&rows).Error
+	if err != nil {
+		return
+	}
+	if len(rows) == 0 {
+		return quota, ErrQuotaExceeded
+	}
+	return rows[0], nil
+}
+
+// untilNextUTCDay is when daily quotas reset.
+func untilNextUTCDay() time.Duration {
+	now := time.Now().UTC()
+	return time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC).Sub(now)
+}
+
+// CheckRateLimit takes a token from the partner's bucket for route and counts the call against its daily quota.
+// It returns ErrRateLimited or ErrQuotaExceeded with status.RetryAfter set when the call must be refused.
+func CheckRateLimit(partnerId, route string) (status models.RateLimitStatus, err error) {
+	limit, err := PartnerRateLimit(partnerId, route)
+	if err != nil {
+		return
+	}
+	status.Limit = limit.Burst
+	status.QuotaLimit = limit.DailyQuota
+
+	allowed, remaining, retryAfter := rateLimiter.Take(fmt.Sprintf("%s_%s", partnerId, route), limit.RatePerSecond, limit.Burst)
+	status.Remaining = remaining
+	if !allowed {
+		status.RetryAfter = retryAfter
+		return status, ErrRateLimited
+	}
+
+	if limit.DailyQuota <= 0 {
+		return
+	}
+	used, err := consumeDailyQuota(partnerId, limit.Route, limit.DailyQuota)
+	status.QuotaRemaining = limit.DailyQuota - used
+	if errors.Is(err, ErrQuotaExceeded) {
+		status.RetryAfter = untilNextUTCDay()
+	}
+	return
+}
+
+// ListRateLimits returns the limits configured for the partner.
+func ListRateLimits(partnerId string) (limits []models.RateLimit, err error) {
+	limits = []models.RateLimit{}
+	err = database.Db.Debug().Table("partner_rate_limits").Where("partner_id = ?", partnerId).Order("route").Find(&limits).Error
+	return
+}
+
+// SetRateLimit creates or replaces the partner's limits on a route. Instances pick the change up within a minute.
+func SetRateLimit(partnerId string, request models.RateLimitRequest) (limit models.RateLimit, err error) {
+	partner, err := strconv.ParseUint(partnerId, 10, 64)
+	if err != nil {
+		return
+	}
+	limit = models.RateLimit{
+		PartnerID:     uint(partner),
+		Route:         request.Route,
+		RatePerSecond: request.RatePerSecond,
+		Burst:         request.Burst,
+		DailyQuota:    request.DailyQuota,
+		CreatedAt:     time.Now(),
+		UpdatedAt:     time.Now(),
+	}
+	err = database.Db.Debug().Table("partner_rate_limits").Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "partner_id"}, {Name: "route"}},
+		DoUpdates: clause.AssignmentColumns([]string{"rate_per_second", "burst", "daily_quota", "updated_at"}),
+	}).Create(&limit).Error
+	return
+}
@@ -0,0 +1,88 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/apeli23/infinity/models"
+)
+
+// TokenIssuer is the iss claim of our access tokens.
+const TokenIssuer = "Zohari Tech"
+
+// legacyScope is the audience tokens carried before scopes existed, and the scope stored with their refresh tokens.
+const legacyScope = "header enrichment"
+
+// TokenAudience is the aud claim of our access tokens, JWT_AUDIENCE or "header enrichment" which tokens
+// carried before scopes existed.
+func TokenAudience() string {
+	if audience := os.Getenv("JWT_AUDIENCE"); audience != "" {
+		return audience
+	}
+	return legacyScope
+}
+
+var readScopes = []string{
+	models.ScopeSubscriptionsRead,
+	models.ScopeTransactionsRead,
+	models.ScopePlansRead,
+	models.ScopeUsersRead,
+}
+
+var partnerScopes = append([]string{
+	models.ScopeSubscriptionsWrite,
+	models.ScopeChargeWrite,
+	models.ScopePlansWrite,
+	models.ScopeUsersWrite,
+	models.ScopeWebhooksWrite,
+	models.ScopeAPIKeysWrite,
+	models.ScopeCredentialsWrite,
+}, readScopes...)
+
+// roleScopes lists the scopes each role may hold.
+var roleScopes = map[string][]string{
+	models.RoleAdmin:    append([]string{models.ScopePartnersAdmin}, partnerScopes...),
+	models.RolePartner:  partnerScopes,
+	models.RoleReadOnly: readScopes,
+}
+
+// RoleScopes returns every scope the role may hold, space separated as in the scope claim.
+func RoleScopes(role string) string {
+	return strings.Join(roleScopes[role], " ")
+}
+
+// GrantScopes returns the scope claim for a token of role. Callers may ask for a narrower, space separated
+// set of scopes; an empty request grants everything the role allows.
+func GrantScopes(role, requested string) (string, error) {
+	if strings.TrimSpace(requested) == "" {
+		return RoleScopes(role), nil
+	}
+
+	granted := []string{}
+	for _, scope := range strings.Fields(requested) {
+		if !HasScopes(RoleScopes(role), scope) {
+			return "", fmt.Errorf("scope %q is not available to role %s", scope, role)
+		}
+		granted = append(granted, scope)
+	}
+	return strings.Join(granted, " "), nil
+}
+
+// HasScopes reports whether the space separated scope claim holds every required scope.
+func HasScopes(claim string, required ...string) bool {
+	held := strings.Fields(claim)
+	for _, scope := range required {
+		found := false
+		for _, candidate := range held {
+			if candidate == scope {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
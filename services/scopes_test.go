@@ -0,0 +1,62 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/apeli23/infinity/models"
+)
+
+func TestGrantScopes(t *testing.T) {
+	tests := []struct {
+		name      string
+		role      string
+		requested string
+		want      string
+		wantErr   bool
+	}{
+		{name: "everything of the role", role: models.RoleReadOnly, requested: " ", want: RoleScopes(models.RoleReadOnly)},
+		{name: "narrower", role: models.RolePartner, requested: models.ScopeSubscriptionsRead + "  " + models.ScopeChargeWrite, want: models.ScopeSubscriptionsRead + " " + models.ScopeChargeWrite},
+		{name: "admin scope for admins", role: models.RoleAdmin, requested: models.ScopePartnersAdmin, want: models.ScopePartnersAdmin},
+		{name: "admin scope for partners", role: models.RolePartner, requested: models.ScopePartnersAdmin, wantErr: true},
+		{name: "write scope for read only users", role: models.RoleReadOnly, requested: models.ScopeSubscriptionsRead + " " + models.ScopeChargeWrite, wantErr: true},
+		{name: "unknown scope", role: models.RoleAdmin, requested: "everything", wantErr: true},
+		{name: "unknown role", role: "guest", requested: models.ScopeSubscriptionsRead, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := GrantScopes(test.role, test.requested)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("GrantScopes() error = %v, want error %v", err, test.wantErr)
+			}
+			if got != test.want {
+				t.Errorf("GrantScopes() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestHasScopes(t *testing.T) {
+	claim := models.ScopeSubscriptionsRead + " " + models.ScopeChargeWrite
+	tests := []struct {
+		name     string
+		claim    string
+		required []string
+		want     bool
+	}{
+		{name: "nothing required", claim: "", want: true},
+		{name: "held", claim: claim, required: []string{models.ScopeChargeWrite}, want: true},
+		{name: "all held", claim: claim, required: []string{models.ScopeChargeWrite, models.ScopeSubscriptionsRead}, want: true},
+		{name: "one missing", claim: claim, required: []string{models.ScopeChargeWrite, models.ScopePlansWrite}, want: false},
+		{name: "prefix only", claim: claim, required: []string{"subscriptions"}, want: false},
+		{name: "empty claim", claim: "", required: []string{models.ScopeSubscriptionsRead}, want: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := HasScopes(test.claim, test.required...); got != test.want {
+				t.Errorf("HasScopes(%q, %v) = %v, want %v", test.claim, test.required, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/apeli23/infinity/models"
+	"github.com/apeli23/infinity/simulator"
+)
+
+// SDPGateway is how we talk to the SDP. Every call returns the raw response body, which is stored with the
+// subscription events, and an error when the request failed or the SDP refused it (a *utils.HTTPError for
+// non 2xx answers). The SDP reports the outcome of accepted requests later through the notification endpoints.
+type SDPGateway interface {
+	// Auth makes sure the gateway holds valid credentials for the SDP
+	Auth() error
+	Activate(request *models.HeRequest) (string, error)
+	WapActivate(request *models.HeRequest) (string, error)
+	Deactivate(request *models.HeRequest) (string, error)
+	Charge(request *models.HeRequest) (string, error)
+}
+
+// sdpSimulator answers every operator's requests when SDP_GATEWAY=simulator.
+var sdpSimulator = newSDPSimulator()
+
+func newSDPSimulator() *simulator.Simulator {
+	if os.Getenv("SDP_GATEWAY") != "simulator" {
+		return nil
+	}
+	logrus.Warn("SDP_GATEWAY=simulator, SDP requests are answered by the in-process simulator")
+	return simulator.New()
+}
+
+// GatewayFor returns the SDPGateway of the operator: the in-process simulator when SDP_GATEWAY=simulator,
+// otherwise the operator's SDP at its base URL.
+func GatewayFor(operator models.Operator) SDPGateway {
+	if sdpSimulator != nil {
+		return sdpSimulator
+	}
+	return httpGateway{operator: operator}
+}
+
+// requestGateway returns the gateway of the operator the request is routed to, once it made sure
+// the gateway can authenticate. Its errors are marked notSent, the request has not been sent yet.
+func requestGateway(request *models.HeRequest) (SDPGateway, error) {
+	operator, err := requestOperator(request)
+	if err != nil {
+		return nil, notSent(err)
+	}
+	gateway := GatewayFor(operator)
+	if err := gateway.Auth(); err != nil {
+		return nil, notSent(err)
+	}
+	return gateway, nil
+}
+
+// httpGateway calls an operator's SDP over HTTP with its HE and SDP tokens.
+type httpGateway struct {
+	operator models.Operator
+}
+
+func (g httpGateway) Auth() error {
+	if _, err := HeLoginToken(g.operator); err != nil {
+		return err
+	}
+	_, err := GetSdpToken(g.operator)
+	return err
+}
+
+// sdpRequest is the body of an SDP operation, checked before it is sent.
+type sdpRequest interface {
+	Validate() error
+}
+
+// post validates the request and sends it, marshalled as JSON, to the SDP operation at path.
+func (g httpGateway) post(path string, request sdpRequest, requestId string) (string, error) {
+	if err := request.Validate(); err != nil {
+		return "", notSent(err)
+	}
+	payload, err := json.Marshal(request)
+	if err != nil {
+		return "", notSent(err)
+	}
+	headers, err := BuildHeaders(g.operator, requestId)
+	if err != nil {
+		return "", notSent(err)
+	}
+	return sendWithReauth(g.operator, string(payload), headers, fmt.Sprintf("%s%s", g.operator.BaseURL, path), requestId)
+}
+
+func (g httpGateway) subscriptionRequest(request *models.HeRequest) models.SdpSubscriptionRequest {
+	return models.NewSdpSubscriptionRequest(request, g.operator.CPID, g.operator.ActDeactCallback)
+}
+
+func (g httpGateway) Activate(request *models.HeRequest) (string, error) {
+	return g.post("/api/v1/activate", g.subscriptionRequest(request), request.ExternalID)
+}
+
+func (g httpGateway) WapActivate(request *models.HeRequest) (string, error) {
+	return g.post("/api/v1/wapActivate", g.subscriptionRequest(request), request.ExternalID)
+}
+
+func (g httpGateway) Deactivate(request *models.HeRequest) (string, error) {
+	return g.post("/api/v1/deactivate", g.subscriptionRequest(request), request.ExternalID)
+}
+
+func (g httpGateway) Charge(request *models.HeRequest) (string, error) {
+	charge := models.NewSdpChargeRequest(request, g.operator.CPID, g.operator.ChargeCallback)
+	return g.post("/api/v1/charge", charge, request.ExternalID)
+}
@@ -1,8 +1,12 @@
 package services
 
 import (
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"math/rand"
-	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -20,18 +24,53 @@ func CheckPasswordHash(password, hash string) bool {
 	return err == nil
 }
 
-func GenerateToken(userID string, scope string) (string, error) {
+// unknownAccountHash is the hash of a random password nobody knows. Logins of accounts that do not exist are checked
+// against it, so they take as long to refuse as a wrong password and do not tell which accounts exist.
+const unknownAccountHash = "$2a$14$Cz/c9iyvJdLzsSKRIdKvLeTTVWZDT700iPnPtrnLyjcBakVWSubhK"
+
+// CheckUnknownAccount spends the time of a password check on a login of an account that does not exist.
+func CheckUnknownAccount(password string) {
+	CheckPasswordHash(password, unknownAccountHash)
+}
+
+// Claims are the JWT claims of our access tokens. PartnerID is the partner the caller acts for and UserID
+// the member of that partner who logged in, empty when the partner logged in with its own credentials.
+// Scope is the space separated list of scopes the token was granted.
+type Claims struct {
+	PartnerID string `json:"pid,omitempty"`
+	UserID    string `json:"uid,omitempty"`
+	Role      string `json:"role"`
+	Scope     string `json:"scope,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// AccessTokenTTL is how long an access token is valid for.
+const AccessTokenTTL = 6 * time.Hour
+
+// GenerateToken signs an access token with the current signing key, see SignToken.
+// Each token gets a random ID (the jti claim) it can be revoked by.
+func GenerateToken(partnerID, userID, role, scope string) (string, error) {
+	jti, err := GenerateSecret(16)
+	if err != nil {
+		return "", err
+	}
 	curentTIme := time.Now()
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
-		ExpiresAt: jwt.NewNumericDate(curentTIme.Add(time.Hour * 6)),
-		IssuedAt:  jwt.NewNumericDate(curentTIme),
-		NotBefore: jwt.NewNumericDate(curentTIme),
-		ID:        userID,
-		Issuer:    "Zohari Tech",
-		Subject:   "Software Outsourcing",
-		Audience:  []string{scope},
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, Claims{
+		PartnerID: partnerID,
+		UserID:    userID,
+		Role:      role,
+		Scope:     scope,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(curentTIme.Add(AccessTokenTTL)),
+			IssuedAt:  jwt.NewNumericDate(curentTIme),
+			NotBefore: jwt.NewNumericDate(curentTIme),
+			ID:        jti,
+			Issuer:    TokenIssuer,
+			Subject:   "Software Outsourcing",
+			Audience:  []string{TokenAudience()},
+		},
 	})
-	return token.SignedString([]byte(os.Getenv("AUTH_SECRET")))
+	return SignToken(token)
 }
 
 func GeneratePassword() string {
@@ -80,4 +119,49 @@ func GeneratePassword() string {
 		inRune[i], inRune[j] = inRune[j], inRune[i]
 	})
 	return string(inRune)
-}
\ No newline at end of file
+}
+
+// GenerateSecret returns a random hex encoded secret of n bytes for webhook signing keys and other opaque tokens.
+func GenerateSecret(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := crand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// HashToken returns the SHA-256 of a high entropy token (invites, refresh tokens, API keys), which is what we store
+// instead of the token itself. Passwords keep using bcrypt.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// EncodeCursor turns the last ID of a page into the opaque cursor handed to API clients.
+func EncodeCursor(id uint) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatUint(uint64(id), 10)))
+}
+
+// DecodeCursor returns the ID a cursor points at, 0 for an empty cursor.
+func DecodeCursor(cursor string) (uint, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, err
+	}
+	id, err := strconv.ParseUint(string(raw), 10, 64)
+	return uint(id), err
+}
+
+// PageLimit clamps the page size requested by a client, defaulting to 50 and allowing at most 200.
+func PageLimit(limit int) int {
+	if limit <= 0 {
+		return 50
+	}
+	if limit > 200 {
+		return 200
+	}
+	return limit
+}
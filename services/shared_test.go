@@ -0,0 +1,96 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+
+	"github.com/apeli23/infinity/models"
+)
+
+func TestCursor(t *testing.T) {
+	tests := []struct {
+		name    string
+		cursor  string
+		want    uint
+		wantErr bool
+	}{
+		{name: "first page", cursor: "", want: 0},
+		{name: "round trip", cursor: EncodeCursor(4096), want: 4096},
+		{name: "not base64", cursor: "%%%", wantErr: true},
+		{name: "not an id", cursor: "YWJj", wantErr: true},
+		{name: "negative id", cursor: "LTE", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := DecodeCursor(test.cursor)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("DecodeCursor(%q) error = %v, want error %v", test.cursor, err, test.wantErr)
+			}
+			if got != test.want {
+				t.Errorf("DecodeCursor(%q) = %d, want %d", test.cursor, got, test.want)
+			}
+		})
+	}
+}
+
+func TestPageLimit(t *testing.T) {
+	tests := []struct {
+		limit int
+		want  int
+	}{
+		{limit: -1, want: 50},
+		{limit: 0, want: 50},
+		{limit: 1, want: 1},
+		{limit: 200, want: 200},
+		{limit: 201, want: 200},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%d", test.limit), func(t *testing.T) {
+			if got := PageLimit(test.limit); got != test.want {
+				t.Errorf("PageLimit(%d) = %d, want %d", test.limit, got, test.want)
+			}
+		})
+	}
+}
+
+func TestGenerateToken(t *testing.T) {
+	setKeyEncryptionKey(t)
+	useKeyring(t, testSigningKey(t, "ES256", time.Now().Add(time.Hour)))
+	tests := []struct {
+		name      string
+		partnerId string
+		userId    string
+		role      string
+	}{
+		{name: "partner credentials", partnerId: "7", role: models.RolePartner},
+		{name: "partner member", partnerId: "7", userId: "12", role: models.RoleReadOnly},
+		{name: "admin without partner", userId: "1", role: models.RoleAdmin},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			signed, err := GenerateToken(test.partnerId, test.userId, test.role, RoleScopes(test.role))
+			if err != nil {
+				t.Fatalf("GenerateToken() error = %v", err)
+			}
+			claims := &Claims{}
+			if _, err := jwt.ParseWithClaims(signed, claims, VerificationKey); err != nil {
+				t.Fatalf("ParseWithClaims() error = %v", err)
+			}
+			if claims.PartnerID != test.partnerId || claims.UserID != test.userId || claims.Role != test.role || claims.Scope != RoleScopes(test.role) {
+				t.Errorf("claims = %+v, want partner %q, user %q, role %q with its scopes", claims, test.partnerId, test.userId, test.role)
+			}
+			if !claims.VerifyIssuer(TokenIssuer, true) || !claims.VerifyAudience(TokenAudience(), true) {
+				t.Errorf("claims issued by %q for %v", claims.Issuer, claims.Audience)
+			}
+			if lifetime := claims.ExpiresAt.Sub(claims.IssuedAt.Time); lifetime != AccessTokenTTL {
+				t.Errorf("token valid for %v, want %v", lifetime, AccessTokenTTL)
+			}
+		})
+	}
+}
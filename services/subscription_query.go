@@ -0,0 +1,77 @@
+package services
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+
+	"github.com/apeli23/infinity/database"
+	"github.com/apeli23/infinity/models"
+)
+
+// partnerSubscriptions scopes a subscriptions query to the plans owned by the partner.
+func partnerSubscriptions(partnerId string) *gorm.DB {
+	return database.Db.Table("subscriptions").
+		Select("subscriptions.*").
+		Joins("JOIN plans ON plans.id = subscriptions.plan_id").
+		Where("plans.partner_id = ?", partnerId)
+}
+
+// ListPartnerSubscriptions returns a page of the partner's subscriptions, newest first, and the cursor of the next page
+// (empty on the last page).
+func ListPartnerSubscriptions(partnerId string, filter models.SubscriptionFilter) (subscriptions []models.Subscription, nextCursor string, err error) {
+	after, err := DecodeCursor(filter.Cursor)
+	if err != nil {
+		return
+	}
+	limit := PageLimit(filter.Limit)
+
+	query := partnerSubscriptions(partnerId)
+	if filter.PlanID != "" {
+		query = query.Where("subscriptions.plan_id = ?", filter.PlanID)
+	}
+	if filter.Msisdn != "" {
+		query = query.Where("subscriptions.msisdn = ?", filter.Msisdn)
+	}
+	if filter.Status != "" {
+		query = query.Where("subscriptions.status = ?", filter.Status)
+	}
+	if !filter.From.IsZero() {
+		query = query.Where("subscriptions.created_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("subscriptions.created_at < ?", filter.To)
+	}
+	if after != 0 {
+		query = query.Where("subscriptions.id < ?", after)
+	}
+
+	// fetch one extra row to know whether there is a next page
+	if err = query.Order("subscriptions.id DESC").Limit(limit + 1).Find(&subscriptions).Error; err != nil {
+		return
+	}
+	if len(subscriptions) > limit {
+		subscriptions = subscriptions[:limit]
+		nextCursor = EncodeCursor(subscriptions[limit-1].ID)
+	}
+	return
+}
+
+// GetPartnerSubscription looks up one of the partner's subscriptions by the requestId it was created with,
+// together with its transactions.
+func GetPartnerSubscription(partnerId, externalId string) (details models.SubscriptionDetails, err error) {
+	err = partnerSubscriptions(partnerId).Where("subscriptions.external_id = ?", externalId).First(&details.Subscription).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// subscriptions merged into another one are found under the one they were merged into
+		err = partnerSubscriptions(partnerId).
+			Joins("JOIN subscription_aliases ON subscription_aliases.subscription_id = subscriptions.id").
+			Where("subscription_aliases.external_id = ?", externalId).First(&details.Subscription).Error
+	}
+	if err != nil {
+		return
+	}
+
+	details.Transactions = []models.Transaction{}
+	err = database.Db.Table("transactions").Where("subscription_id = ?", details.Subscription.ID).Order("id DESC").Find(&details.Transactions).Error
+	return
+}
@@ -0,0 +1,248 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/apeli23/infinity/models"
+	"github.com/apeli23/infinity/utils"
+)
+
+// tokenRefresh is a refresh in flight, every caller that needs the token meanwhile waits for it.
+type tokenRefresh struct {
+	done chan struct{}
+	err  error
+}
+
+// TokenSource keeps an access token of an upstream (HE or SDP) valid: it fetches it on first use, refreshes it
+// in the background TOKEN_REFRESH_AHEAD (5m) before it expires and coalesces concurrent refreshes into one call.
+type TokenSource struct {
+	name  string
+	fetch func() (token string, expiresIn time.Duration, err error)
+
+	mu        sync.Mutex
+	value     string
+	expiresAt time.Time
+	lifetime  time.Duration
+	inflight  *tokenRefresh
+}
+
+func NewTokenSource(name string, fetch func() (string, time.Duration, error)) *TokenSource {
+	source := &TokenSource{name: name, fetch: fetch}
+	go source.keepFresh()
+	return source
+}
+
+// current returns the token unless it expires within TOKEN_EXPIRY_SKEW (30s).
+func (s *TokenSource) current() (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	skew := utils.GetEnvDuration("TOKEN_EXPIRY_SKEW", 30*time.Second)
+	return s.value, s.value != "" && time.Now().Add(skew).Before(s.expiresAt)
+}
+
+// refresh fetches a new token, or waits for the refresh already in flight.
+func (s *TokenSource) refresh() error {
+	s.mu.Lock()
+	if call := s.inflight; call != nil {
+		s.mu.Unlock()
+		<-call.done
+		return call.err
+	}
+	call := &tokenRefresh{done: make(chan struct{})}
+	s.inflight = call
+	s.mu.Unlock()
+
+	token, expiresIn, err := s.fetch()
+
+	s.mu.Lock()
+	if err == nil {
+		s.value, s.expiresAt, s.lifetime = token, time.Now().Add(expiresIn), expiresIn
+		logrus.Infof("%s TOKEN REFRESHED | EXPIRES IN : %v", s.name, expiresIn)
+	}
+	s.inflight = nil
+	s.mu.Unlock()
+
+	call.err = err
+	close(call.done)
+	return err
+}
+
+// Token returns a valid token, fetching one when needed.
+func (s *TokenSource) Token() (string, error) {
+	if token, ok := s.current(); ok {
+		return token, nil
+	}
+	if err := s.refresh(); err != nil {
+		return "", err
+	}
+	if token, ok := s.current(); ok {
+		return token, nil
+	}
+	return "", fmt.Errorf("%s token expired on arrival", s.name)
+}
+
+// Invalidate drops the token when it is still stale, the one the upstream refused. Callers that got refused
+// with the same token at the same time then share a single refresh.
+func (s *TokenSource) Invalidate(stale string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.value == stale {
+		s.value = ""
+	}
+}
+
+// keepFresh refreshes the token ahead of its expiry once it has been used, so requests never wait for it.
+func (s *TokenSource) keepFresh() {
+	for {
+		<-time.After(30 * time.Second)
+
+		s.mu.Lock()
+		used := s.value != ""
+		ahead := utils.GetEnvDuration("TOKEN_REFRESH_AHEAD", 5*time.Minute)
+		if ahead > s.lifetime/2 {
+			ahead = s.lifetime / 2
+		}
+		due := time.Until(s.expiresAt) < ahead
+		s.mu.Unlock()
+
+		if used && due {
+			if err := s.refresh(); err != nil {
+				logrus.Errorf("%s TOKEN REFRESH FAILED | ERROR : %v", s.name, err)
+			}
+		}
+	}
+}
+
+// tokenExpiresIn reads the expires_in field of a token response, sent as a number or a string of seconds.
+// Responses without it keep the token for TOKEN_DEFAULT_TTL (50m).
+func tokenExpiresIn(value interface{}) time.Duration {
+	seconds := 0.0
+	switch v := value.(type) {
+	case float64:
+		seconds = v
+	case string:
+		seconds, _ = strconv.ParseFloat(v, 64)
+	}
+	if seconds <= 0 {
+		return utils.GetEnvDuration("TOKEN_DEFAULT_TTL", 50*time.Minute)
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// authRetryPolicy is the retry policy of token requests, whose credentials and tokens are kept out of the logs.
+func authRetryPolicy() utils.RetryPolicy {
+	policy := utils.RetryPolicyFor(true)
+	policy.Redact = true
+	return policy
+}
+
+func fetchHeToken(operator models.Operator) (token string, expiresIn time.Duration, err error) {
+	auth := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", operator.HeUsername, operator.HePassword)))
+	res, err := utils.RequestWithRetry(authRetryPolicy(), "", map[string][]string{
+		"Content-Type":  {"application/x-www-form-urlencoded"},
+		"Authorization": {fmt.Sprintf("Basic %s", auth)},
+	}, operator.HeAuthURL, "POST")
+	if err != nil {
+		return
+	}
+
+	response := models.HeAuthResponse{}
+	if err = json.Unmarshal([]byte(res), &response); err != nil {
+		return
+	}
+	if response.AccessToken == "" {
+		return "", 0, errors.New("HE auth response has no access_token")
+	}
+	return response.AccessToken, tokenExpiresIn(response.ExpiresIn), nil
+}
+
+func fetchSdpToken(operator models.Operator) (token string, expiresIn time.Duration, err error) {
+	credentials := models.SdpAuthRequest{Username: operator.SdpUsername, Password: operator.SdpPassword}
+	if err = credentials.Validate(); err != nil {
+		return
+	}
+	payload, err := json.Marshal(credentials)
+	if err != nil {
+		return
+	}
+	res, err := utils.RequestWithRetry(authRetryPolicy(), string(payload), map[string][]string{
+		"Content-Type":     {`application/json`},
+		"Accept":           {`application/json`},
+		"X-Requested-With": {"XMLHttpRequest"},
+	}, operator.SdpAuthURL, "POST")
+	if err != nil {
+		return
+	}
+
+	response := models.SdpAuthResponse{}
+	if err = json.Unmarshal([]byte(res), &response); err != nil {
+		return
+	}
+	if response.Token == "" {
+		return "", 0, errors.New("SDP auth response has no token")
+	}
+	return response.Token, tokenExpiresIn(response.ExpiresIn), nil
+}
+
+// operatorTokens are the HE and SDP tokens of one operator.
+type operatorTokens struct {
+	he  *TokenSource
+	sdp *TokenSource
+}
+
+var (
+	tokenSourcesMu sync.Mutex
+	tokenSources   = map[string]*operatorTokens{}
+)
+
+// tokensFor returns the token sources of the operator, created on first use.
+func tokensFor(operator models.Operator) *operatorTokens {
+	tokenSourcesMu.Lock()
+	defer tokenSourcesMu.Unlock()
+
+	tokens, ok := tokenSources[operator.Code]
+	if !ok {
+		tokens = &operatorTokens{
+			he: NewTokenSource(fmt.Sprintf("%s HE", operator.Code), func() (string, time.Duration, error) {
+				return fetchHeToken(operator)
+			}),
+			sdp: NewTokenSource(fmt.Sprintf("%s SDP", operator.Code), func() (string, time.Duration, error) {
+				return fetchSdpToken(operator)
+			}),
+		}
+		tokenSources[operator.Code] = tokens
+	}
+	return tokens
+}
+
+// sendWithReauth sends an SDP request built with BuildHeaders. When the SDP answers 401 the tokens it was sent
+// with are invalidated and the request is sent once more with fresh ones.
+func sendWithReauth(operator models.Operator, payload string, headers map[string][]string, url, requestId string) (response string, err error) {
+	response, err = utils.RequestWithRetry(utils.RetryPolicyFor(false), payload, headers, url, "POST")
+
+	var httpErr *utils.HTTPError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusUnauthorized {
+		return
+	}
+	logrus.Warnf("SEND REQUEST | URL : %s | 401 FROM SDP, REFRESHING TOKENS AND RETRYING ONCE", url)
+	tokens := tokensFor(operator)
+	tokens.he.Invalidate(strings.TrimPrefix(headers["Authorization"][0], "Bearer "))
+	tokens.sdp.Invalidate(strings.TrimPrefix(headers["X-api-auth-token"][0], "Bearer "))
+
+	fresh, buildErr := BuildHeaders(operator, requestId)
+	if buildErr != nil {
+		logrus.Error(buildErr)
+		return
+	}
+	return utils.RequestWithRetry(utils.RetryPolicyFor(false), payload, fresh, url, "POST")
+}
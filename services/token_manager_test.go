@@ -0,0 +1,114 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingFetch returns a fetch function that hands out numbered tokens valid for ttl, after delay.
+func countingFetch(calls *int32, ttl, delay time.Duration, err error) func() (string, time.Duration, error) {
+	return func() (string, time.Duration, error) {
+		call := atomic.AddInt32(calls, 1)
+		time.Sleep(delay)
+		if err != nil {
+			return "", 0, err
+		}
+		return fmt.Sprintf("token-%d", call), ttl, nil
+	}
+}
+
+func TestTokenSourceSingleFlight(t *testing.T) {
+	var calls int32
+	source := NewTokenSource("TEST", countingFetch(&calls, time.Hour, 20*time.Millisecond, nil))
+
+	var wg sync.WaitGroup
+	tokens := make([]string, 20)
+	for i := range tokens {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			token, err := source.Token()
+			if err != nil {
+				t.Errorf("Token() error = %v", err)
+			}
+			tokens[i] = token
+		}(i)
+	}
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("fetch called %d times, want 1", calls)
+	}
+	for _, token := range tokens {
+		if token != "token-1" {
+			t.Errorf("Token() = %q, want token-1", token)
+		}
+	}
+}
+
+func TestTokenSource(t *testing.T) {
+	tests := []struct {
+		name       string
+		ttl        time.Duration
+		fetchErr   error
+		invalidate string
+		wantToken  string
+		wantErr    bool
+		wantCalls  int32
+	}{
+		{name: "cached", ttl: time.Hour, wantToken: "token-1", wantCalls: 1},
+		{name: "expiring within the skew", ttl: time.Second, wantErr: true, wantCalls: 2},
+		{name: "fetch failure is retried", ttl: time.Hour, fetchErr: errors.New("sdp down"), wantErr: true, wantCalls: 2},
+		{name: "refused token refreshed", ttl: time.Hour, invalidate: "token-1", wantToken: "token-2", wantCalls: 2},
+		{name: "already refreshed token kept", ttl: time.Hour, invalidate: "token-0", wantToken: "token-1", wantCalls: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var calls int32
+			source := NewTokenSource("TEST", countingFetch(&calls, test.ttl, 0, test.fetchErr))
+			// the first call fetches, the second one is checked
+			source.Token()
+			if test.invalidate != "" {
+				source.Invalidate(test.invalidate)
+			}
+			token, err := source.Token()
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Token() error = %v, want error %v", err, test.wantErr)
+			}
+			if token != test.wantToken {
+				t.Errorf("Token() = %q, want %q", token, test.wantToken)
+			}
+			if calls != test.wantCalls {
+				t.Errorf("fetch called %d times, want %d", calls, test.wantCalls)
+			}
+		})
+	}
+}
+
+func TestTokenExpiresIn(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		want  time.Duration
+	}{
+		{name: "number", value: 3599.0, want: 3599 * time.Second},
+		{name: "string", value: "3600", want: time.Hour},
+		{name: "fraction", value: 1.5, want: 1500 * time.Millisecond},
+		{name: "absent", value: nil, want: 50 * time.Minute},
+		{name: "zero", value: 0.0, want: 50 * time.Minute},
+		{name: "not a number", value: "soon", want: 50 * time.Minute},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := tokenExpiresIn(test.value); got != test.want {
+				t.Errorf("tokenExpiresIn(%v) = %v, want %v", test.value, got, test.want)
+			}
+		})
+	}
+}
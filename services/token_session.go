@@ -0,0 +1,168 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/apeli23/infinity/database"
+	"github.com/apeli23/infinity/models"
+	"github.com/apeli23/infinity/utils"
+)
+
+var ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+
+// IssueTokenPair signs an access token and stores a new refresh token for it. familyId ties the refresh token to the
+// login it descends from, an empty familyId starts a new family.
+func IssueTokenPair(partnerId, userId, role, scope, familyId string) (pair models.TokenPair, err error) {
+	return issueTokenPair(database.Db, partnerId, userId, role, scope, familyId)
+}
+
+func issueTokenPair(tx *gorm.DB, partnerId, userId, role, scope, familyId string) (pair models.TokenPair, err error) {
+	if pair.Token, err = GenerateToken(partnerId, userId, role, scope); err != nil {
+		return
+	}
+	if pair.RefreshToken, err = GenerateSecret(32); err != nil {
+		return
+	}
+	if familyId == "" {
+		if familyId, err = GenerateSecret(16); err != nil {
+			return
+		}
+	}
+
+	refresh := models.RefreshToken{
+		TokenHash: HashToken(pair.RefreshToken),
+		FamilyID:  familyId,
+		PartnerID: partnerId,
+		UserID:    userId,
+		Role:      role,
+		Scope:     scope,
+		ExpiresAt: time.Now().Add(utils.GetEnvDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour)),
+	}
+	if err = tx.Debug().Table("refresh_tokens").Create(&refresh).Error; err != nil {
+		return
+	}
+	pair.ExpiresIn = int64(AccessTokenTTL.Seconds())
+	return
+}
+
+// refreshIdentityActive checks that the account a refresh token was issued to may still log in.
+func refreshIdentityActive(tx *gorm.DB, refresh models.RefreshToken) bool {
+	if refresh.UserID == "" {
+		// partners logging in with their own credentials
+		var count int64
+		tx.Debug().Table("partners").Where("id = ?", refresh.PartnerID).Count(&count)
+		return count > 0
+	}
+
+	user := models.User{}
+	if err := tx.Debug().Table("users").Where("id = ?", refresh.UserID).First(&user).Error; err != nil || !user.Active {
+		return false
+	}
+	if refresh.PartnerID == "" {
+		return user.Role == models.RoleAdmin
+	}
+	var count int64
+	tx.Debug().Table("partner_users").Where("partner_id = ? AND user_id = ? AND active", refresh.PartnerID, refresh.UserID).Count(&count)
+	return count > 0
+}
+
+// RefreshTokenPair exchanges a refresh token for a new token pair. The presented refresh token is revoked (rotation);
+// presenting an already revoked one means it leaked, so every token of its family is revoked.
+func RefreshTokenPair(refreshToken string) (pair models.TokenPair, err error) {
+	reused := false
+	err = database.Db.Transaction(func(tx *gorm.DB) error {
+		refresh := models.RefreshToken{}
+		if err := tx.Debug().Table("refresh_tokens").Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ?", HashToken(refreshToken)).First(&refresh).Error; err != nil {
+			return ErrInvalidRefreshToken
+		}
+		if refresh.RevokedAt != nil {
+			reused = true
+			return ErrInvalidRefreshToken
+		}
+		if refresh.ExpiresAt.Before(time.Now()) || !refreshIdentityActive(tx, refresh) {
+			return ErrInvalidRefreshToken
+		}
+
+		if err := tx.Debug().Table("refresh_tokens").Where("id = ?", refresh.ID).Update("revoked_at", time.Now()).Error; err != nil {
+			return err
+		}
+		// refresh tokens issued before scopes existed carry the old audience as their scope, they keep the role's
+		// scopes. Any other scope the role no longer allows fails the refresh rather than widening the token.
+		scope := RoleScopes(refresh.Role)
+		if refresh.Scope != legacyScope {
+			granted, err := GrantScopes(refresh.Role, refresh.Scope)
+			if err != nil {
+				logrus.Warnf("refresh token %d: %v", refresh.ID, err)
+				return ErrInvalidRefreshToken
+			}
+			scope = granted
+		}
+		var err error
+		pair, err = issueTokenPair(tx, refresh.PartnerID, refresh.UserID, refresh.Role, scope, refresh.FamilyID)
+		return err
+	})
+
+	if reused {
+		logrus.Warn("revoked refresh token presented, revoking its family")
+		if revokeErr := revokeRefreshFamily(refreshToken); revokeErr != nil {
+			logrus.Error(revokeErr)
+		}
+	}
+	return
+}
+
+func revokeRefreshFamily(refreshToken string) error {
+	return database.Db.Debug().Table("refresh_tokens").
+		Where("family_id = (SELECT family_id FROM refresh_tokens WHERE token_hash = ?) AND revoked_at IS NULL", HashToken(refreshToken)).
+		Update("revoked_at", time.Now()).Error
+}
+
+// RevokeRefreshToken revokes one of the caller's refresh tokens.
+func RevokeRefreshToken(refreshToken, partnerId, userId string) error {
+	return database.Db.Debug().Table("refresh_tokens").
+		Where("token_hash = ? AND partner_id = ? AND user_id = ? AND revoked_at IS NULL", HashToken(refreshToken), partnerId, userId).
+		Update("revoked_at", time.Now()).Error
+}
+
+// RevokeAccessToken adds the token's jti to the revocation list until the token expires anyway.
+func RevokeAccessToken(jti string, expiresAt time.Time) error {
+	revoked := models.RevokedToken{
+		JTI:       jti,
+		ExpiresAt: expiresAt,
+	}
+	if err := database.Db.Debug().Table("revoked_tokens").Clauses(clause.OnConflict{DoNothing: true}).Create(&revoked).Error; err != nil {
+		return err
+	}
+	utils.CacheInstance.Set(revokedCacheKey(jti), true, time.Until(expiresAt))
+	return nil
+}
+
+func revokedCacheKey(jti string) string {
+	return fmt.Sprintf("REVOKED_TOKEN_%s", jti)
+}
+
+// IsTokenRevoked reports whether the access token with this jti was revoked. Revocations are cached until the token
+// expires; tokens that are not revoked are always looked up so a revocation on another instance applies immediately.
+func IsTokenRevoked(jti string) (bool, error) {
+	if _, ok := utils.CacheInstance.Get(revokedCacheKey(jti)); ok {
+		return true, nil
+	}
+
+	revoked := models.RevokedToken{}
+	err := database.Db.Table("revoked_tokens").Where("jti = ?", jti).Limit(1).Find(&revoked).Error
+	if err != nil {
+		return false, err
+	}
+	if revoked.JTI == "" {
+		return false, nil
+	}
+	utils.CacheInstance.Set(revokedCacheKey(jti), true, time.Until(revoked.ExpiresAt))
+	return true, nil
+}
@@ -0,0 +1,74 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+
+	"github.com/apeli23/infinity/utils"
+)
+
+func TestHashToken(t *testing.T) {
+	tests := []struct {
+		token string
+		want  string
+	}{
+		{token: "", want: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+		{token: "abc", want: "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.token, func(t *testing.T) {
+			if got := HashToken(test.token); got != test.want {
+				t.Errorf("HashToken(%q) = %s, want %s", test.token, got, test.want)
+			}
+		})
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	seen := map[string]bool{}
+	for _, n := range []int{4, 16, 32} {
+		secret, err := GenerateSecret(n)
+		if err != nil {
+			t.Fatalf("GenerateSecret(%d) error = %v", n, err)
+		}
+		if len(secret) != 2*n || seen[secret] {
+			t.Errorf("GenerateSecret(%d) = %q, want %d new hex characters", n, secret, 2*n)
+		}
+		seen[secret] = true
+	}
+}
+
+func TestTokenIDs(t *testing.T) {
+	setKeyEncryptionKey(t)
+	useKeyring(t, testSigningKey(t, "ES256", time.Now().Add(time.Hour)))
+
+	seen := map[string]bool{}
+	for i := 0; i < 10; i++ {
+		signed, err := GenerateToken("7", "", "partner", "")
+		if err != nil {
+			t.Fatalf("GenerateToken() error = %v", err)
+		}
+		claims := &Claims{}
+		if _, _, err := jwt.NewParser().ParseUnverified(signed, claims); err != nil {
+			t.Fatalf("ParseUnverified() error = %v", err)
+		}
+		if claims.ID == "" || seen[claims.ID] {
+			t.Fatalf("token %d has jti %q, want a new one for every token", i, claims.ID)
+		}
+		seen[claims.ID] = true
+	}
+}
+
+func TestIsTokenRevokedCached(t *testing.T) {
+	jti, _ := GenerateSecret(16)
+	utils.CacheInstance.Set(revokedCacheKey(jti), true, time.Minute)
+
+	// revocations are answered from the cache, without a database
+	revoked, err := IsTokenRevoked(jti)
+	if err != nil || !revoked {
+		t.Errorf("IsTokenRevoked() = %v, %v, want true", revoked, err)
+	}
+}
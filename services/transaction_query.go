@@ -0,0 +1,102 @@
+package services
+
+import (
+	"gorm.io/gorm"
+
+	"github.com/apeli23/infinity/database"
+	"github.com/apeli23/infinity/models"
+)
+
+// transactionAmount is the numeric value of the free-form amount column, NULL for amounts that are not plain decimals
+// so one malformed row cannot fail the whole query. The pattern avoids ? because gorm reads it as a placeholder.
+const transactionAmount = "CASE WHEN transactions.amount ~ '^-{0,1}[0-9]+([.][0-9]+){0,1}$' " +
+	"THEN CAST(transactions.amount AS NUMERIC) END"
+
+// partnerTransactions scopes a transactions query to the partner's plans and applies filter, without paging.
+func partnerTransactions(partnerId string, filter models.TransactionFilter) *gorm.DB {
+	query := database.Db.Table("transactions").
+		Joins("JOIN subscriptions ON subscriptions.id = transactions.subscription_id").
+		Joins("JOIN plans ON plans.id = subscriptions.plan_id").
+		Where("plans.partner_id = ?", partnerId)
+
+	if filter.PlanID != "" {
+		query = query.Where("subscriptions.plan_id = ?", filter.PlanID)
+	}
+	if filter.Msisdn != "" {
+		query = query.Where("subscriptions.msisdn = ?", filter.Msisdn)
+	}
+	if filter.Status != "" {
+		query = query.Where("transactions.status = ?", filter.Status)
+	}
+	if !filter.From.IsZero() {
+		query = query.Where("transactions.created_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("transactions.created_at < ?", filter.To)
+	}
+	if filter.MinAmount != nil {
+		query = query.Where(transactionAmount+" >= ?", *filter.MinAmount)
+	}
+	if filter.MaxAmount != nil {
+		query = query.Where(transactionAmount+" <= ?", *filter.MaxAmount)
+	}
+	return query
+}
+
+// ListPartnerTransactions returns a page of the partner's transactions, newest first, and the cursor of the next page
+// (empty on the last page).
+func ListPartnerTransactions(partnerId string, filter models.TransactionFilter) (transactions []models.TransactionRecord, nextCursor string, err error) {
+	after, err := DecodeCursor(filter.Cursor)
+	if err != nil {
+		return
+	}
+	limit := PageLimit(filter.Limit)
+
+	query := partnerTransactions(partnerId, filter).Select("transactions.*, subscriptions.plan_id, subscriptions.msisdn")
+	if after != 0 {
+		query = query.Where("transactions.id < ?", after)
+	}
+
+	if err = query.Order("transactions.id DESC").Limit(limit + 1).Find(&transactions).Error; err != nil {
+		return
+	}
+	if len(transactions) > limit {
+		transactions = transactions[:limit]
+		nextCursor = EncodeCursor(transactions[limit-1].ID)
+	}
+	return
+}
+
+// PartnerTransactionTotals counts and sums the partner's transactions matching filter per status.
+func PartnerTransactionTotals(partnerId string, filter models.TransactionFilter) (totals []models.TransactionTotals, err error) {
+	err = partnerTransactions(partnerId, filter).
+		Select("transactions.status AS status, COUNT(*) AS count, COALESCE(SUM(" + transactionAmount + "), 0) AS amount").
+		Group("transactions.status").
+		Order("transactions.status").
+		Scan(&totals).Error
+	return
+}
+
+// ExportPartnerTransactions streams every partner transaction matching filter to write, oldest first,
+// without loading the whole result set in memory. Paging fields of the filter are ignored.
+func ExportPartnerTransactions(partnerId string, filter models.TransactionFilter, write func(models.TransactionRecord) error) error {
+	rows, err := partnerTransactions(partnerId, filter).
+		Select("transactions.*, subscriptions.plan_id, subscriptions.msisdn").
+		Order("transactions.id").
+		Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		record := models.TransactionRecord{}
+		if err := database.Db.ScanRows(rows, &record); err != nil {
+			return err
+		}
+		if err := write(record); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
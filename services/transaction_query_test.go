@@ -0,0 +1,47 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/apeli23/infinity/models"
+)
+
+func TestPartnerTransactionsFilter(t *testing.T) {
+	min, max := 10.0, 99.5
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		filter   models.TransactionFilter
+		contains []string
+		vars     int
+	}{
+		{name: "partner only", contains: []string{"plans.partner_id = $1"}, vars: 1},
+		{name: "plan and msisdn", filter: models.TransactionFilter{PlanID: "1029", Msisdn: "254712345678"},
+			contains: []string{"subscriptions.plan_id = $2", "subscriptions.msisdn = $3"}, vars: 3},
+		{name: "status and period", filter: models.TransactionFilter{Status: "Successful", From: from, To: from.AddDate(0, 1, 0)},
+			contains: []string{"transactions.status = $2", "transactions.created_at >= $3", "transactions.created_at < $4"}, vars: 4},
+		// the amount pattern must reach postgres as written, not have its quantifiers taken for placeholders
+		{name: "amount range", filter: models.TransactionFilter{MinAmount: &min, MaxAmount: &max},
+			contains: []string{"'^-{0,1}[0-9]+([.][0-9]+){0,1}$'", "END >= $2", "END <= $3"}, vars: 3},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			statement := partnerTransactions("7", test.filter).Session(&gorm.Session{DryRun: true}).
+				Find(&[]models.TransactionRecord{}).Statement
+			sql := statement.SQL.String()
+			for _, want := range test.contains {
+				if !strings.Contains(sql, want) {
+					t.Errorf("query %s does not contain %s", sql, want)
+				}
+			}
+			if len(statement.Vars) != test.vars {
+				t.Errorf("query bound %d values %v, want %d", len(statement.Vars), statement.Vars, test.vars)
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package services
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/apeli23/infinity/database"
+	"github.com/apeli23/infinity/models"
+	"github.com/apeli23/infinity/utils"
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid login credentials")
+	ErrInvalidInvite      = errors.New("invalid or expired invite")
+	ErrAlreadyMember      = errors.New("user already belongs to this partner")
+	ErrMemberNotFound     = errors.New("user does not belong to this partner")
+)
+
+func GetUserByEmail(email string) (user models.User, err error) {
+	err = database.Db.Debug().Table("users").Where("email = ?", email).First(&user).Error
+	return
+}
+
+// UserLogin checks a user's credentials and returns the user with the partner membership the token is issued for.
+// Admins do not need to belong to a partner, in which case membership is empty.
+func UserLogin(login models.UserLogin) (user models.User, membership models.PartnerUsers, err error) {
+	if user, err = GetUserByEmail(login.Username); err != nil {
+		CheckUnknownAccount(login.Password)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			err = ErrInvalidCredentials
+		}
+		return
+	}
+	hash := user.Password
+	if hash == "" {
+		// invited users who have not set their password yet
+		hash = unknownAccountHash
+	}
+	if !CheckPasswordHash(login.Password, hash) || !user.Active || user.Password == "" {
+		err = ErrInvalidCredentials
+		return
+	}
+
+	query := database.Db.Debug().Table("partner_users").Where("user_id = ? AND active", user.ID)
+	if login.PartnerID != 0 {
+		query = query.Where("partner_id = ?", login.PartnerID)
+	}
+	err = query.Order("id").First(&membership).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		if user.Role == models.RoleAdmin && login.PartnerID == 0 {
+			err = nil
+			return
+		}
+		err = ErrInvalidCredentials
+	}
+	return
+}
+
+// InviteUser adds a user to the partner. Users that do not exist yet are created without a password and
+// inviteToken is the one-time token they set it with; it is empty for users that already have an account.
+func InviteUser(partnerId string, invite models.UserInvite) (member models.PartnerMember, inviteToken string, err error) {
+	partner, err := strconv.ParseUint(partnerId, 10, 64)
+	if err != nil {
+		return
+	}
+
+	err = database.Db.Transaction(func(tx *gorm.DB) error {
+		user := models.User{}
+		err := tx.Debug().Table("users").Where("email = ?", invite.Email).First(&user).Error
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			token, err := GenerateSecret(32)
+			if err != nil {
+				return err
+			}
+			expiresAt := time.Now().Add(utils.GetEnvDuration("INVITE_TTL", 72*time.Hour))
+			user = models.User{
+				FirstName:       invite.FirstName,
+				LastName:        invite.LastName,
+				Email:           invite.Email,
+				Role:            models.RoleReadOnly,
+				Active:          true,
+				InviteTokenHash: HashToken(token),
+				InviteExpiresAt: &expiresAt,
+			}
+			if err := tx.Debug().Table("users").Create(&user).Error; err != nil {
+				return err
+			}
+			inviteToken = token
+		case err != nil:
+			return err
+		}
+
+		membership := models.PartnerUsers{}
+		err = tx.Debug().Table("partner_users").Where("partner_id = ? AND user_id = ?", partner, user.ID).Limit(1).Find(&membership).Error
+		switch {
+		case err != nil:
+			return err
+		case membership.ID == 0:
+			membership = models.PartnerUsers{
+				UserID:    user.ID,
+				PartnerID: uint(partner),
+				Role:      invite.Role,
+				Active:    true,
+			}
+			if err := tx.Debug().Table("partner_users").Create(&membership).Error; err != nil {
+				return err
+			}
+		case membership.Active:
+			return ErrAlreadyMember
+		default:
+			// members deactivated earlier are invited back on their existing membership
+			membership.Role = invite.Role
+			membership.Active = true
+			if err := tx.Debug().Table("partner_users").Where("id = ?", membership.ID).Updates(map[string]interface{}{
+				"role":       membership.Role,
+				"active":     true,
+				"updated_at": time.Now(),
+			}).Error; err != nil {
+				return err
+			}
+		}
+
+		member = models.PartnerMember{
+			UserID:    user.ID,
+			Email:     user.Email,
+			FirstName: user.FirstName,
+			LastName:  user.LastName,
+			Role:      membership.Role,
+			Active:    true,
+			Invited:   inviteToken != "",
+			CreatedAt: membership.CreatedAt,
+		}
+		return nil
+	})
+	return
+}
+
+// AcceptInvite sets the password of the user the invite token was issued to and consumes the token.
+func AcceptInvite(acceptance models.InviteAcceptance) error {
+	user := models.User{}
+	if err := database.Db.Debug().Table("users").Where("invite_token_hash = ?", HashToken(acceptance.Token)).First(&user).Error; err != nil {
+		return ErrInvalidInvite
+	}
+	if user.InviteExpiresAt == nil || user.InviteExpiresAt.Before(time.Now()) {
+		return ErrInvalidInvite
+	}
+
+	password, err := HashPassword(acceptance.Password)
+	if err != nil {
+		return err
+	}
+	return database.Db.Debug().Table("users").Where("id = ?", user.ID).Updates(map[string]interface{}{
+		"password":          password,
+		"invite_token_hash": "",
+		"invite_expires_at": nil,
+		"updated_at":        time.Now(),
+	}).Error
+}
+
+// ListPartnerMembers returns every user of the partner, deactivated ones included.
+func ListPartnerMembers(partnerId string) (members []models.PartnerMember, err error) {
+	members = []models.PartnerMember{}
+	err = database.Db.Debug().Table("partner_users").
+		Select("users.id AS user_id, users.email, users.firstname, users.lastname, partner_users.role, "+
+			"partner_users.active, users.invite_token_hash <> '' AS invited, partner_users.created_at").
+		Joins("JOIN users ON users.id = partner_users.user_id").
+		Where("partner_users.partner_id = ?", partnerId).
+		Order("partner_users.id").
+		Scan(&members).Error
+	return
+}
+
+// DeactivateMember removes the user's access to the partner and revokes their refresh tokens for it and the API keys
+// they created for it.
+// The user keeps access to other partners they belong to.
+func DeactivateMember(partnerId, userId string) error {
+	return database.Db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		result := tx.Debug().Table("partner_users").
+			Where("partner_id = ? AND user_id = ?", partnerId, userId).
+			Updates(map[string]interface{}{"active": false, "updated_at": now})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrMemberNotFound
+		}
+		if err := tx.Debug().Table("refresh_tokens").
+			Where("partner_id = ? AND user_id = ? AND revoked_at IS NULL", partnerId, userId).
+			Update("revoked_at", now).Error; err != nil {
+			return err
+		}
+		return tx.Debug().Table("partner_api_keys").
+			Where("partner_id = ? AND created_by_user_id = ? AND revoked_at IS NULL", partnerId, userId).
+			Update("revoked_at", now).Error
+	})
+}
+
+// MembershipActive reports whether the user still belongs to the partner. Access tokens outlive a deactivation,
+// so they are checked against the membership on every request.
+func MembershipActive(partnerId, userId string) (bool, error) {
+	var count int64
+	err := database.Db.Debug().Table("partner_users").Where("partner_id = ? AND user_id = ? AND active", partnerId, userId).Count(&count).Error
+	return count > 0, err
+}
@@ -0,0 +1,239 @@
+// Package simulator is an in-process stand-in for the Safaricom SDP, used to exercise the activation and charge
+// flows without it. It answers requests with the response bodies the SDP sends and, like the SDP, reports the
+// outcome later by posting a notification to the callback URL of the request.
+//
+// It is configured from the environment:
+//   - SDP_SIMULATOR_LATENCY (100ms): time taken to answer every request
+//   - SDP_SIMULATOR_FAILURE_RATE (0): share of requests, between 0 and 1, that fail with SDP_SIMULATOR_FAILURE_MODE
+//   - SDP_SIMULATOR_FAILURE_MODE (error): error answers 503, reject answers 400 with an SDP error body,
+//     unauthorized answers 401 and timeout never answers within HTTP_RESPONSE_TIMEOUT
+//   - SDP_SIMULATOR_INSUFFICIENT_FUNDS_RATE (0): share of accepted charges notified as failed for lack of funds
+//   - SDP_SIMULATOR_CALLBACK_DELAY (2s): time between the answer and the notification
+//
+// Notifications go to ACT_DEACT_NOTIFICATION and CHARGE_CALLBACK, the callback URLs the real SDP is given, and
+// carry SDP_NOTIFICATION_SECRET and an SDP_NOTIFICATION_HMAC_SECRET signature when those are set.
+//
+// Requests are checked against the SDP request schema of package models, with CPID (simulator) as content
+// provider, and refused with 400 when they do not satisfy it, as the HTTP gateway refuses to send them.
+package simulator
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	mrand "math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/apeli23/infinity/models"
+	"github.com/apeli23/infinity/utils"
+	"github.com/apeli23/infinity/webhook"
+)
+
+// SDP subscription statuses as sent in the SubscriptionStatus notification item.
+const (
+	statusActive   = "A"
+	statusInactive = "D"
+)
+
+var ErrTimeout = errors.New("simulated SDP timeout")
+
+// Simulator implements the SDP gateway of package services. It remembers the subscriptions it activated so
+// that it refuses to activate them twice or deactivate unknown ones, as the SDP does.
+type Simulator struct {
+	mu            sync.Mutex
+	subscriptions map[string]string
+}
+
+func New() *Simulator {
+	return &Simulator{subscriptions: map[string]string{}}
+}
+
+func envRate(key string) float64 {
+	rate, err := strconv.ParseFloat(os.Getenv(key), 64)
+	if err != nil {
+		return 0
+	}
+	return rate
+}
+
+func newID() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// response builds an SDP answer body.
+func response(requestId string, code int, message, status, description, statusCode string) string {
+	body, _ := json.Marshal(models.HeResponse{
+		Header: models.HeResponseHeader{
+			RequestRefId:    requestId,
+			ResponseCode:    code,
+			ResponseMessage: message,
+			CustomerMessage: message,
+			Timestamp:       time.Now().Format("2006-01-02T15:04:05.000"),
+		},
+		Body: models.HeResponseBody{
+			Status:      status,
+			Description: description,
+			StatusCode:  statusCode,
+		},
+	})
+	return string(body)
+}
+
+// rejected builds the answer to a request the SDP refuses, returned with a *utils.HTTPError like utils.Request does.
+func rejected(requestId string, code int, description string) (string, error) {
+	body := response(requestId, code, "Request rejected", "FAILED", description, fmt.Sprintf("%d", code))
+	return body, &utils.HTTPError{StatusCode: code, Body: body}
+}
+
+// answer waits SDP_SIMULATOR_LATENCY and decides whether the request fails with the configured failure mode.
+func (s *Simulator) answer(requestId string) (failed bool, body string, err error) {
+	time.Sleep(utils.GetEnvDuration("SDP_SIMULATOR_LATENCY", 100*time.Millisecond))
+	if mrand.Float64() >= envRate("SDP_SIMULATOR_FAILURE_RATE") {
+		return false, "", nil
+	}
+
+	switch os.Getenv("SDP_SIMULATOR_FAILURE_MODE") {
+	case "reject":
+		body, err = rejected(requestId, http.StatusBadRequest, "Invalid request")
+	case "unauthorized":
+		body, err = rejected(requestId, http.StatusUnauthorized, "Invalid access token")
+	case "timeout":
+		time.Sleep(utils.GetEnvDuration("HTTP_RESPONSE_TIMEOUT", 30*time.Second))
+		err = ErrTimeout
+	default:
+		body, err = rejected(requestId, http.StatusServiceUnavailable, "Service temporarily unavailable")
+	}
+	return true, body, err
+}
+
+func cpId() string {
+	if id := os.Getenv("CPID"); id != "" {
+		return id
+	}
+	return "simulator"
+}
+
+func subscriptionKey(request *models.HeRequest) string {
+	return request.Msisdn + "|" + request.OfferCode
+}
+
+// notify posts the notification the SDP sends once it processed a request.
+func (s *Simulator) notify(url string, data []models.DataItem) {
+	time.Sleep(utils.GetEnvDuration("SDP_SIMULATOR_CALLBACK_DELAY", 2*time.Second))
+
+	body, _ := json.Marshal(models.Callback{
+		RequestId:    newID(),
+		RequestParam: models.CallbackParams{Data: data},
+	})
+	headers := map[string][]string{
+		"Content-Type": {"application/json"},
+	}
+	if secret := os.Getenv("SDP_NOTIFICATION_SECRET"); secret != "" {
+		headerName := os.Getenv("SDP_NOTIFICATION_SECRET_HEADER")
+		if headerName == "" {
+			headerName = "X-Notification-Secret"
+		}
+		headers[headerName] = []string{secret}
+	}
+	if secret := os.Getenv("SDP_NOTIFICATION_HMAC_SECRET"); secret != "" {
+		timestamp := time.Now().Unix()
+		headers[webhook.TimestampHeader] = []string{fmt.Sprintf("%d", timestamp)}
+		headers[webhook.SignatureHeader] = []string{webhook.Header([]string{secret}, timestamp, body)}
+	}
+
+	if _, err := utils.Request(string(body), headers, url, "POST"); err != nil {
+		logrus.Errorf("SDP SIMULATOR | NOTIFICATION FAILED | URL : %s | ERROR : %v", url, err)
+	}
+}
+
+func (s *Simulator) Auth() error {
+	if os.Getenv("SDP_SIMULATOR_FAILURE_MODE") == "unauthorized" && mrand.Float64() < envRate("SDP_SIMULATOR_FAILURE_RATE") {
+		return &utils.HTTPError{StatusCode: http.StatusUnauthorized, Body: "invalid credentials"}
+	}
+	return nil
+}
+
+// subscribe moves the subscription to status and notifies the outcome, refusing changes that make no sense
+// to the SDP.
+func (s *Simulator) subscribe(request *models.HeRequest, status, accepted string) (string, error) {
+	if failed, body, err := s.answer(request.ExternalID); failed {
+		return body, err
+	}
+	sdpRequest := models.NewSdpSubscriptionRequest(request, cpId(), os.Getenv("ACT_DEACT_NOTIFICATION"))
+	if err := sdpRequest.Validate(); err != nil {
+		return rejected(request.ExternalID, http.StatusBadRequest, err.Error())
+	}
+
+	s.mu.Lock()
+	current := s.subscriptions[subscriptionKey(request)]
+	switch {
+	case status == statusActive && current == statusActive:
+		s.mu.Unlock()
+		return rejected(request.ExternalID, http.StatusConflict, "Subscriber already active on this offer")
+	case status == statusInactive && current != statusActive:
+		s.mu.Unlock()
+		return rejected(request.ExternalID, http.StatusNotFound, "Subscriber not active on this offer")
+	}
+	s.subscriptions[subscriptionKey(request)] = status
+	s.mu.Unlock()
+
+	go s.notify(sdpRequest.CallBackUrl, []models.DataItem{
+		{Name: "ClientTransactionId", Value: request.ExternalID},
+		{Name: "OfferCode", Value: request.OfferCode},
+		{Name: "Msisdn", Value: request.Msisdn},
+		{Name: "SubscriptionStatus", Value: status},
+	})
+	return response(request.ExternalID, http.StatusOK, "Service processing successful", "SUCCESS", accepted, "0"), nil
+}
+
+func (s *Simulator) Activate(request *models.HeRequest) (string, error) {
+	return s.subscribe(request, statusActive, "Activation request accepted for processing")
+}
+
+func (s *Simulator) WapActivate(request *models.HeRequest) (string, error) {
+	return s.subscribe(request, statusActive, "WAP activation request accepted for processing")
+}
+
+func (s *Simulator) Deactivate(request *models.HeRequest) (string, error) {
+	return s.subscribe(request, statusInactive, "Deactivation request accepted for processing")
+}
+
+func (s *Simulator) Charge(request *models.HeRequest) (string, error) {
+	if failed, body, err := s.answer(request.ExternalID); failed {
+		return body, err
+	}
+	sdpRequest := models.NewSdpChargeRequest(request, cpId(), os.Getenv("CHARGE_CALLBACK"))
+	if err := sdpRequest.Validate(); err != nil {
+		return rejected(request.ExternalID, http.StatusBadRequest, err.Error())
+	}
+
+	s.mu.Lock()
+	active := s.subscriptions[subscriptionKey(request)] == statusActive
+	s.mu.Unlock()
+	if !active {
+		return rejected(request.ExternalID, http.StatusNotFound, "Subscriber not active on this offer")
+	}
+
+	reason := "Successful"
+	if mrand.Float64() < envRate("SDP_SIMULATOR_INSUFFICIENT_FUNDS_RATE") {
+		reason = "Insufficient funds"
+	}
+	go s.notify(sdpRequest.CallBackUrl, []models.DataItem{
+		{Name: "ClientTransactionId", Value: request.ExternalID},
+		{Name: "OfferCode", Value: request.OfferCode},
+		{Name: "Msisdn", Value: request.Msisdn},
+		{Name: "ChargeAmount", Value: request.ChargeAmount},
+		{Name: "Reason", Value: reason},
+	})
+	return response(request.ExternalID, http.StatusOK, "Service processing successful", "SUCCESS", "Charge request accepted for processing", "0"), nil
+}
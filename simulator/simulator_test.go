@@ -0,0 +1,190 @@
+package simulator
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/apeli23/infinity/models"
+	"github.com/apeli23/infinity/utils"
+	"github.com/apeli23/infinity/webhook"
+)
+
+func quickSimulator(t *testing.T) {
+	t.Setenv("SDP_SIMULATOR_LATENCY", "0s")
+	t.Setenv("SDP_SIMULATOR_CALLBACK_DELAY", "0s")
+	t.Setenv("ACT_DEACT_NOTIFICATION", "http://127.0.0.1:1/notification")
+	t.Setenv("CHARGE_CALLBACK", "http://127.0.0.1:1/charge")
+}
+
+func request(msisdn string) *models.HeRequest {
+	return &models.HeRequest{ExternalID: "r1", Msisdn: msisdn, OfferCode: "1029", ChargeAmount: "10"}
+}
+
+// statusOf returns the HTTP status the simulator answered with, 200 for accepted requests.
+func statusOf(t *testing.T, body string, err error) int {
+	t.Helper()
+	var httpErr *utils.HTTPError
+	if errors.As(err, &httpErr) {
+		if httpErr.Body != body {
+			t.Errorf("error body %q differs from the answer %q", httpErr.Body, body)
+		}
+		return httpErr.StatusCode
+	}
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	return http.StatusOK
+}
+
+func TestSimulatorSubscriptions(t *testing.T) {
+	quickSimulator(t)
+	type step struct {
+		call   string
+		msisdn string
+		want   int
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{name: "activate", steps: []step{{"activate", "254712345678", 200}}},
+		{name: "activate twice", steps: []step{{"activate", "254712345678", 200}, {"wap", "254712345678", 409}}},
+		{name: "deactivate", steps: []step{{"activate", "254712345678", 200}, {"deactivate", "254712345678", 200}, {"charge", "254712345678", 404}}},
+		{name: "deactivate unknown", steps: []step{{"deactivate", "254712345678", 404}}},
+		{name: "reactivate", steps: []step{{"activate", "254712345678", 200}, {"deactivate", "254712345678", 200}, {"activate", "254712345678", 200}}},
+		{name: "charge active", steps: []step{{"activate", "254712345678", 200}, {"charge", "254712345678", 200}}},
+		{name: "charge other subscriber", steps: []step{{"activate", "254712345678", 200}, {"charge", "254711111111", 404}}},
+		{name: "invalid msisdn", steps: []step{{"activate", "0712'", 400}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sdp := New()
+			calls := map[string]func(*models.HeRequest) (string, error){
+				"activate":   sdp.Activate,
+				"wap":        sdp.WapActivate,
+				"deactivate": sdp.Deactivate,
+				"charge":     sdp.Charge,
+			}
+			for i, step := range test.steps {
+				body, err := calls[step.call](request(step.msisdn))
+				if got := statusOf(t, body, err); got != step.want {
+					t.Fatalf("step %d %s = %d, want %d: %s", i+1, step.call, got, step.want, body)
+				}
+			}
+		})
+	}
+}
+
+func TestSimulatorFailureModes(t *testing.T) {
+	quickSimulator(t)
+	t.Setenv("HTTP_RESPONSE_TIMEOUT", "1ms")
+	tests := []struct {
+		mode     string
+		rate     string
+		want     int
+		wantErr  error
+		authFail bool
+	}{
+		{mode: "error", rate: "1", want: http.StatusServiceUnavailable},
+		{mode: "", rate: "1", want: http.StatusServiceUnavailable},
+		{mode: "reject", rate: "1", want: http.StatusBadRequest},
+		{mode: "unauthorized", rate: "1", want: http.StatusUnauthorized, authFail: true},
+		{mode: "timeout", rate: "1", wantErr: ErrTimeout},
+		{mode: "error", rate: "0", want: http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.mode+" at "+test.rate, func(t *testing.T) {
+			t.Setenv("SDP_SIMULATOR_FAILURE_MODE", test.mode)
+			t.Setenv("SDP_SIMULATOR_FAILURE_RATE", test.rate)
+			sdp := New()
+			if err := sdp.Auth(); (err != nil) != test.authFail {
+				t.Errorf("Auth() error = %v, want error %v", err, test.authFail)
+			}
+			body, err := sdp.Activate(request("254712345678"))
+			if test.wantErr != nil {
+				if !errors.Is(err, test.wantErr) {
+					t.Errorf("Activate() error = %v, want %v", err, test.wantErr)
+				}
+				return
+			}
+			if got := statusOf(t, body, err); got != test.want {
+				t.Errorf("Activate() = %d, want %d", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSimulatorNotifications(t *testing.T) {
+	quickSimulator(t)
+	t.Setenv("SDP_NOTIFICATION_HMAC_SECRET", "hmac")
+	t.Setenv("SDP_NOTIFICATION_SECRET", "shared")
+
+	received := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- r
+		bodies <- body
+	}))
+	defer server.Close()
+	t.Setenv("ACT_DEACT_NOTIFICATION", server.URL+"/notification")
+	t.Setenv("CHARGE_CALLBACK", server.URL+"/charge")
+
+	sdp := New()
+	tests := []struct {
+		name string
+		call func(*models.HeRequest) (string, error)
+		path string
+		want string
+	}{
+		{name: "activation", call: sdp.Activate, path: "/notification", want: `{"ClientTransactionId":"r1","OfferCode":"1029","Msisdn":"254712345678","SubscriptionStatus":"A"}`},
+		{name: "charge", call: sdp.Charge, path: "/charge", want: `{"ClientTransactionId":"r1","OfferCode":"1029","Msisdn":"254712345678","ChargeAmount":"10","Reason":"Successful"}`},
+		{name: "deactivation", call: sdp.Deactivate, path: "/notification", want: `{"ClientTransactionId":"r1","OfferCode":"1029","Msisdn":"254712345678","SubscriptionStatus":"D"}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := test.call(request("254712345678")); err != nil {
+				t.Fatalf("call error = %v", err)
+			}
+			var r *http.Request
+			select {
+			case r = <-received:
+			case <-time.After(5 * time.Second):
+				t.Fatal("no notification received")
+			}
+			body := <-bodies
+
+			if r.URL.Path != test.path {
+				t.Errorf("notified %s, want %s", r.URL.Path, test.path)
+			}
+			if got := r.Header.Get("X-Notification-Secret"); got != "shared" {
+				t.Errorf("shared secret header = %q", got)
+			}
+			if err := webhook.Verify(r.Header.Get(webhook.SignatureHeader), r.Header.Get(webhook.TimestampHeader), body, time.Minute, "hmac"); err != nil {
+				t.Errorf("signature: %v", err)
+			}
+
+			callback := models.Callback{}
+			if err := json.Unmarshal(body, &callback); err != nil {
+				t.Fatalf("notification body: %v", err)
+			}
+			var data interface{}
+			if test.path == "/charge" {
+				data, _ = models.DecodeChargeNotification(callback.RequestParam)
+			} else {
+				data, _ = models.DecodeSubscriptionNotification(callback.RequestParam)
+			}
+			if got, _ := json.Marshal(data); string(got) != test.want {
+				t.Errorf("notification data = %s, want %s", got, test.want)
+			}
+		})
+	}
+}
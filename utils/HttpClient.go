@@ -0,0 +1,139 @@
+package utils
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	ErrCircuitOpen = errors.New("upstream unavailable, circuit open")
+	ErrHostBusy    = errors.New("too many requests in flight to upstream")
+)
+
+// HttpClient is the client every outbound request goes through, see newHttpClient for its timeouts.
+var HttpClient = newHttpClient()
+
+// newHttpClient builds a client with its own transport so that a slow upstream times out instead of
+// holding gin workers forever:
+//   - HTTP_CONNECT_TIMEOUT (5s) to open the connection and complete the TLS handshake
+//   - HTTP_RESPONSE_TIMEOUT (30s) for the response headers once the request is sent
+//   - HTTP_REQUEST_TIMEOUT (60s) for the whole exchange, body included
+func newHttpClient() *http.Client {
+	connectTimeout := GetEnvDuration("HTTP_CONNECT_TIMEOUT", 5*time.Second)
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: connectTimeout, KeepAlive: 30 * time.Second}).DialContext,
+		TLSHandshakeTimeout:   connectTimeout,
+		ResponseHeaderTimeout: GetEnvDuration("HTTP_RESPONSE_TIMEOUT", 30*time.Second),
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   GetEnvInt("HTTP_MAX_IN_FLIGHT_PER_HOST", 20),
+		IdleConnTimeout:       90 * time.Second,
+		// NOTE: the SDP endpoints are called without verifying their certificate, as before
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}
+	return &http.Client{
+		Transport: transport,
+		Timeout:   GetEnvDuration("HTTP_REQUEST_TIMEOUT", 60*time.Second),
+	}
+}
+
+// circuit state of one upstream host. It opens after HTTP_BREAKER_FAILURES (5) consecutive failures and
+// refuses requests for HTTP_BREAKER_COOLDOWN (30s), after which one probe request decides whether it closes again.
+type circuit struct {
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+func (c *circuit) allow() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.failures < GetEnvInt("HTTP_BREAKER_FAILURES", 5) {
+		return nil
+	}
+	if time.Now().Before(c.openUntil) || c.probing {
+		return ErrCircuitOpen
+	}
+	// half open: let a single request through to probe the upstream
+	c.probing = true
+	return nil
+}
+
+func (c *circuit) record(failed bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.probing = false
+	if !failed {
+		c.failures = 0
+		return
+	}
+	c.failures++
+	if c.failures >= GetEnvInt("HTTP_BREAKER_FAILURES", 5) {
+		c.openUntil = time.Now().Add(GetEnvDuration("HTTP_BREAKER_COOLDOWN", 30*time.Second))
+	}
+}
+
+// upstream guards one host with an in-flight limit and a circuit breaker.
+type upstream struct {
+	slots   chan struct{}
+	circuit circuit
+}
+
+var upstreams sync.Map
+
+func upstreamFor(host string) *upstream {
+	value, _ := upstreams.LoadOrStore(host, &upstream{
+		slots: make(chan struct{}, GetEnvInt("HTTP_MAX_IN_FLIGHT_PER_HOST", 20)),
+	})
+	return value.(*upstream)
+}
+
+// slotBody gives the in-flight slot back once the caller is done reading the response.
+type slotBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *slotBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+// guardedDo sends req through HttpClient once an in-flight slot of the host frees up within HTTP_QUEUE_TIMEOUT (2s)
+// and its circuit lets it. Network errors and 5xx responses count as failures of the host.
+func guardedDo(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	guard := upstreamFor(host)
+
+	select {
+	case guard.slots <- struct{}{}:
+	case <-time.After(GetEnvDuration("HTTP_QUEUE_TIMEOUT", 2*time.Second)):
+		return nil, fmt.Errorf("%w: %s", ErrHostBusy, host)
+	}
+	release := func() { <-guard.slots }
+
+	if err := guard.circuit.allow(); err != nil {
+		release()
+		return nil, fmt.Errorf("%w: %s", err, host)
+	}
+
+	res, err := HttpClient.Do(req)
+	guard.circuit.record(err != nil || res.StatusCode >= 500)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	res.Body = &slotBody{ReadCloser: res.Body, release: release}
+	return res, nil
+}
@@ -0,0 +1,105 @@
+package utils
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestCircuit(t *testing.T) {
+	t.Setenv("HTTP_BREAKER_FAILURES", "2")
+	t.Setenv("HTTP_BREAKER_COOLDOWN", "20ms")
+
+	// each step records an outcome ("ok", "fail"), waits out the cooldown ("wait") or asks to send ("allow")
+	tests := []struct {
+		name  string
+		steps []string
+		want  error
+	}{
+		{name: "closed", steps: []string{"fail"}, want: nil},
+		{name: "success resets failures", steps: []string{"fail", "ok", "fail"}, want: nil},
+		{name: "opens", steps: []string{"fail", "fail"}, want: ErrCircuitOpen},
+		{name: "half open after cooldown", steps: []string{"fail", "fail", "wait"}, want: nil},
+		{name: "single probe", steps: []string{"fail", "fail", "wait", "allow"}, want: ErrCircuitOpen},
+		{name: "failed probe reopens", steps: []string{"fail", "fail", "wait", "allow", "fail"}, want: ErrCircuitOpen},
+		{name: "successful probe closes", steps: []string{"fail", "fail", "wait", "allow", "ok"}, want: nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := &circuit{}
+			for _, step := range test.steps {
+				switch step {
+				case "ok", "fail":
+					c.record(step == "fail")
+				case "wait":
+					time.Sleep(30 * time.Millisecond)
+				case "allow":
+					if err := c.allow(); err != nil {
+						t.Fatalf("allow() before the last step = %v", err)
+					}
+				}
+			}
+			if got := c.allow(); !errors.Is(got, test.want) {
+				t.Errorf("allow() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestGuardedDo(t *testing.T) {
+	t.Setenv("HTTP_MAX_IN_FLIGHT_PER_HOST", "1")
+	t.Setenv("HTTP_QUEUE_TIMEOUT", "20ms")
+	t.Setenv("HTTP_BREAKER_FAILURES", "2")
+	t.Setenv("HTTP_BREAKER_COOLDOWN", "1h")
+
+	get := func(server *httptest.Server) (*http.Response, error) {
+		reqURL, _ := url.Parse(server.URL)
+		return guardedDo(&http.Request{Method: "GET", URL: reqURL, Header: http.Header{}})
+	}
+
+	t.Run("host busy while a response is open", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer server.Close()
+
+		open, err := get(server)
+		if err != nil {
+			t.Fatalf("first request: %v", err)
+		}
+		if _, err := get(server); !errors.Is(err, ErrHostBusy) {
+			t.Errorf("second request error = %v, want %v", err, ErrHostBusy)
+		}
+		open.Body.Close()
+		res, err := get(server)
+		if err != nil {
+			t.Fatalf("request after close: %v", err)
+		}
+		res.Body.Close()
+	})
+
+	t.Run("circuit opens on 5xx", func(t *testing.T) {
+		calls := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+		defer server.Close()
+
+		for i := 0; i < 2; i++ {
+			res, err := get(server)
+			if err != nil {
+				t.Fatalf("request %d: %v", i+1, err)
+			}
+			res.Body.Close()
+		}
+		if _, err := get(server); !errors.Is(err, ErrCircuitOpen) {
+			t.Errorf("request on open circuit error = %v, want %v", err, ErrCircuitOpen)
+		}
+		if calls != 2 {
+			t.Errorf("upstream calls = %d, want 2", calls)
+		}
+	})
+}
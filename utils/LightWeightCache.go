@@ -43,6 +43,26 @@ func (c *Cache) Set(key string, value interface{}, expiration time.Duration) {
 	c.data.Store(key, entry)
 }
 
+// This method adds a key-value pair only if the key is not already cached (or has expired).
+// It returns false when a live entry exists, which makes it usable as a "seen before" check.
+func (c *Cache) Add(key string, value interface{}, expiration time.Duration) bool {
+	entry := cacheEntry{
+		value:      value,
+		expiration: time.Now().Add(expiration),
+	}
+	for {
+		existing, loaded := c.data.LoadOrStore(key, entry)
+		if !loaded {
+			return true
+		}
+		if existing.(cacheEntry).expiration.After(time.Now()) {
+			return false
+		}
+		// only drop the expired entry we saw, not one another caller stored meanwhile
+		c.data.CompareAndDelete(key, existing)
+	}
+}
+
 //This method runs in a separate goroutine and purges any entries from the cache whose expiration time has passed.
 func (c *Cache) purgeExpiredEntries() {
 	for {
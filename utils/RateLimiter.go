@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// tokenBucket refills at rate tokens per second up to burst tokens, every allowed request takes one.
+type tokenBucket struct {
+	mu      sync.Mutex
+	tokens  float64
+	updated time.Time
+}
+
+// RateLimiter keeps one token bucket per key. The rate and burst are passed on every call so that
+// configuration changes apply without resetting the buckets.
+type RateLimiter struct {
+	buckets sync.Map
+}
+
+// Take takes a token from the bucket of key. It returns whether the request is allowed, the tokens left
+// and, when refused, how long until the next token is available.
+func (l *RateLimiter) Take(key string, rate float64, burst int) (allowed bool, remaining int, retryAfter time.Duration) {
+	now := time.Now()
+	value, _ := l.buckets.LoadOrStore(key, &tokenBucket{tokens: float64(burst), updated: now})
+	bucket := value.(*tokenBucket)
+
+	bucket.mu.Lock()
+	defer bucket.mu.Unlock()
+
+	bucket.tokens = math.Min(float64(burst), bucket.tokens+now.Sub(bucket.updated).Seconds()*rate)
+	bucket.updated = now
+	if bucket.tokens < 1 {
+		if rate <= 0 {
+			return false, 0, time.Hour
+		}
+		return false, 0, time.Duration((1 - bucket.tokens) / rate * float64(time.Second))
+	}
+	bucket.tokens--
+	return true, int(bucket.tokens), 0
+}
+
+// purgeIdleBuckets drops the buckets that have not been used for a while, they would be full again anyway.
+func (l *RateLimiter) purgeIdleBuckets() {
+	for {
+		<-time.After(10 * time.Minute)
+		l.buckets.Range(func(key, value interface{}) bool {
+			bucket := value.(*tokenBucket)
+			bucket.mu.Lock()
+			idle := time.Since(bucket.updated) > 10*time.Minute
+			bucket.mu.Unlock()
+			if idle {
+				l.buckets.Delete(key)
+			}
+			return true
+		})
+	}
+}
+
+// NewRateLimiter creates a RateLimiter. Its idle buckets are kept until StartPurging is called.
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{}
+}
+
+// StartPurging starts the goroutine that purges the idle buckets of the limiter.
+func (l *RateLimiter) StartPurging() {
+	go l.purgeIdleBuckets()
+}
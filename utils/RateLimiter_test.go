@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiterTake(t *testing.T) {
+	tests := []struct {
+		name          string
+		rate          float64
+		burst         int
+		takes         int
+		wait          time.Duration
+		wantAllowed   bool
+		wantRemaining int
+		maxRetryAfter time.Duration
+	}{
+		{name: "first request", rate: 1, burst: 3, takes: 1, wantAllowed: true, wantRemaining: 2},
+		{name: "burst used up", rate: 1, burst: 3, takes: 3, wantAllowed: true, wantRemaining: 0},
+		{name: "beyond burst", rate: 1, burst: 3, takes: 4, wantAllowed: false, maxRetryAfter: time.Second},
+		{name: "refilled", rate: 100, burst: 1, takes: 1, wait: 20 * time.Millisecond, wantAllowed: true, wantRemaining: 0},
+		{name: "no burst", rate: 1, burst: 0, takes: 1, wantAllowed: false, maxRetryAfter: time.Second},
+		{name: "no rate", rate: 0, burst: 1, takes: 2, wantAllowed: false, maxRetryAfter: time.Hour},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			limiter := NewRateLimiter()
+			if test.wait > 0 {
+				limiter.Take("partner", test.rate, test.burst)
+				time.Sleep(test.wait)
+			}
+			var allowed bool
+			var remaining int
+			var retryAfter time.Duration
+			for i := 0; i < test.takes; i++ {
+				allowed, remaining, retryAfter = limiter.Take("partner", test.rate, test.burst)
+			}
+			if allowed != test.wantAllowed || remaining != test.wantRemaining {
+				t.Errorf("Take() = %v, %d, want %v, %d", allowed, remaining, test.wantAllowed, test.wantRemaining)
+			}
+			if !allowed && (retryAfter <= 0 || retryAfter > test.maxRetryAfter) {
+				t.Errorf("retryAfter = %v, want between 0 and %v", retryAfter, test.maxRetryAfter)
+			}
+			if allowed && retryAfter != 0 {
+				t.Errorf("retryAfter = %v on an allowed request", retryAfter)
+			}
+		})
+	}
+}
+
+func TestRateLimiterKeys(t *testing.T) {
+	limiter := NewRateLimiter()
+	if allowed, _, _ := limiter.Take("1_/activation", 1, 1); !allowed {
+		t.Fatal("first request of partner 1 refused")
+	}
+	if allowed, _, _ := limiter.Take("1_/activation", 1, 1); allowed {
+		t.Error("second request of partner 1 allowed")
+	}
+	if allowed, _, _ := limiter.Take("2_/activation", 1, 1); !allowed {
+		t.Error("partner 2 limited by partner 1's bucket")
+	}
+}
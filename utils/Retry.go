@@ -0,0 +1,157 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// HTTPError is returned by Request when the upstream answers with a non 2xx status.
+// Its message is the status code alone, as callers have always logged it.
+type HTTPError struct {
+	StatusCode int
+	Body       string
+	// RetryAfter is the delay asked for by the upstream's Retry-After header, zero when absent
+	RetryAfter time.Duration
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("%d", e.StatusCode)
+}
+
+// parseRetryAfter reads a Retry-After header given either in seconds or as an HTTP date.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(date); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
+
+// RetryPolicy says how RequestWithRetry retries a request.
+//
+// Idempotent requests are retried on any network error and on 429, 500, 502, 503 and 504. Other requests may
+// already have taken effect when a response goes missing, so they are only retried when the upstream surely did
+// not process them: the connection could not be opened, or it answered 429 or 503.
+type RetryPolicy struct {
+	Idempotent  bool
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	// Budget caps the time spent on the call, waits included; no attempt starts once it would be exceeded
+	Budget time.Duration
+	// Redact keeps the request and response bodies out of the logs, for calls that carry credentials or tokens
+	Redact bool
+}
+
+// RetryPolicyFor reads the retry policy from HTTP_RETRY_ATTEMPTS (3), HTTP_RETRY_BASE (200ms),
+// HTTP_RETRY_MAX (5s) and HTTP_RETRY_BUDGET (15s).
+func RetryPolicyFor(idempotent bool) RetryPolicy {
+	return RetryPolicy{
+		Idempotent:  idempotent,
+		MaxAttempts: GetEnvInt("HTTP_RETRY_ATTEMPTS", 3),
+		BaseDelay:   GetEnvDuration("HTTP_RETRY_BASE", 200*time.Millisecond),
+		MaxDelay:    GetEnvDuration("HTTP_RETRY_MAX", 5*time.Second),
+		Budget:      GetEnvDuration("HTTP_RETRY_BUDGET", 15*time.Second),
+	}
+}
+
+// NotProcessed reports whether err shows the upstream surely did not process the request: it was never sent
+// (open circuit, saturated host, connection not opened) or the upstream answered 429 or 503.
+// As a non idempotent request is only retried on those errors, it also holds for the last error of RequestWithRetry.
+func NotProcessed(err error) bool {
+	if errors.Is(err, ErrCircuitOpen) || errors.Is(err, ErrHostBusy) {
+		return true
+	}
+
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.StatusCode == http.StatusTooManyRequests || httpErr.StatusCode == http.StatusServiceUnavailable
+	}
+
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+func (p RetryPolicy) retryable(err error) bool {
+	// an open circuit or a saturated host will not recover within a retry budget
+	if errors.Is(err, ErrCircuitOpen) || errors.Is(err, ErrHostBusy) {
+		return false
+	}
+	if NotProcessed(err) {
+		return true
+	}
+
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		switch httpErr.StatusCode {
+		case http.StatusInternalServerError, http.StatusBadGateway, http.StatusGatewayTimeout:
+			return p.Idempotent
+		}
+		return false
+	}
+	return p.Idempotent
+}
+
+// backoff returns the wait before the attempt following attempt: the upstream's Retry-After when it sent one,
+// else a random delay up to BaseDelay doubled for every attempt made and capped at MaxDelay ("full jitter").
+func (p RetryPolicy) backoff(attempt int, err error) time.Duration {
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) && httpErr.RetryAfter > 0 {
+		return httpErr.RetryAfter
+	}
+
+	ceiling := p.BaseDelay
+	for i := 1; i < attempt && ceiling < p.MaxDelay; i++ {
+		ceiling *= 2
+	}
+	if ceiling > p.MaxDelay {
+		ceiling = p.MaxDelay
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+// RequestWithRetry sends the request like Request, retrying it as the policy allows. The returned body and
+// error are those of the last attempt.
+func RequestWithRetry(policy RetryPolicy, request string, headers map[string][]string, urlPath string, method string) (response string, err error) {
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		response, err = send(request, headers, urlPath, method, policy.Redact)
+		if err == nil {
+			if attempt > 1 {
+				logrus.Infof("SEND REQUEST | URL : %s | METHOD : %s | SUCCEEDED AFTER %d ATTEMPTS", urlPath, method, attempt)
+			}
+			return
+		}
+		if attempt >= policy.MaxAttempts || !policy.retryable(err) {
+			if attempt > 1 {
+				logrus.Errorf("SEND REQUEST | URL : %s | METHOD : %s | GAVE UP AFTER %d ATTEMPTS | ERROR : %v", urlPath, method, attempt, err)
+			}
+			return
+		}
+
+		delay := policy.backoff(attempt, err)
+		if policy.Budget > 0 && time.Since(start)+delay > policy.Budget {
+			logrus.Errorf("SEND REQUEST | URL : %s | METHOD : %s | RETRY BUDGET EXHAUSTED AFTER %d ATTEMPTS | ERROR : %v", urlPath, method, attempt, err)
+			return
+		}
+		logrus.Warnf("SEND REQUEST | URL : %s | METHOD : %s | ATTEMPT %d/%d FAILED | RETRY IN %v | ERROR : %v", urlPath, method, attempt, policy.MaxAttempts, delay, err)
+		<-time.After(delay)
+	}
+}
@@ -0,0 +1,155 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		min, max time.Duration
+	}{
+		{name: "absent", value: "", min: 0, max: 0},
+		{name: "seconds", value: "5", min: 5 * time.Second, max: 5 * time.Second},
+		{name: "zero seconds", value: "0", min: 0, max: 0},
+		{name: "negative seconds", value: "-3", min: 0, max: 0},
+		{name: "garbage", value: "soon", min: 0, max: 0},
+		{name: "future date", value: time.Now().Add(time.Minute).UTC().Format(http.TimeFormat), min: 58 * time.Second, max: time.Minute},
+		{name: "past date", value: time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat), min: 0, max: 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := parseRetryAfter(test.value); got < test.min || got > test.max {
+				t.Errorf("parseRetryAfter(%q) = %v, want between %v and %v", test.value, got, test.min, test.max)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyRetryable(t *testing.T) {
+	status := func(code int) error { return &HTTPError{StatusCode: code} }
+	dial := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	read := &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")}
+	tests := []struct {
+		name          string
+		err           error
+		notProcessed  bool
+		idempotent    bool
+		notIdempotent bool
+	}{
+		{name: "circuit open", err: fmt.Errorf("%w: sdp.example.com", ErrCircuitOpen), notProcessed: true},
+		{name: "host busy", err: fmt.Errorf("%w: sdp.example.com", ErrHostBusy), notProcessed: true},
+		{name: "connection refused", err: dial, notProcessed: true, idempotent: true, notIdempotent: true},
+		{name: "connection reset", err: read, idempotent: true},
+		{name: "429", err: status(http.StatusTooManyRequests), notProcessed: true, idempotent: true, notIdempotent: true},
+		{name: "503", err: status(http.StatusServiceUnavailable), notProcessed: true, idempotent: true, notIdempotent: true},
+		{name: "500", err: status(http.StatusInternalServerError), idempotent: true},
+		{name: "502", err: status(http.StatusBadGateway), idempotent: true},
+		{name: "504", err: status(http.StatusGatewayTimeout), idempotent: true},
+		{name: "400", err: status(http.StatusBadRequest)},
+		{name: "401", err: status(http.StatusUnauthorized)},
+		{name: "wrapped 503", err: fmt.Errorf("activation: %w", status(http.StatusServiceUnavailable)), notProcessed: true, idempotent: true, notIdempotent: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := NotProcessed(test.err); got != test.notProcessed {
+				t.Errorf("NotProcessed() = %v, want %v", got, test.notProcessed)
+			}
+			if got := (RetryPolicy{Idempotent: true}).retryable(test.err); got != test.idempotent {
+				t.Errorf("idempotent retryable() = %v, want %v", got, test.idempotent)
+			}
+			if got := (RetryPolicy{}).retryable(test.err); got != test.notIdempotent {
+				t.Errorf("non idempotent retryable() = %v, want %v", got, test.notIdempotent)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	tests := []struct {
+		name    string
+		policy  RetryPolicy
+		attempt int
+		err     error
+		min     time.Duration
+		max     time.Duration
+	}{
+		{name: "first attempt", policy: policy, attempt: 1, err: errors.New("reset"), max: 100 * time.Millisecond},
+		{name: "third attempt", policy: policy, attempt: 3, err: errors.New("reset"), max: 400 * time.Millisecond},
+		{name: "capped", policy: policy, attempt: 10, err: errors.New("reset"), max: time.Second},
+		{name: "no base delay", policy: RetryPolicy{}, attempt: 3, err: errors.New("reset"), max: 0},
+		{name: "retry after", policy: policy, attempt: 1, err: &HTTPError{StatusCode: 429, RetryAfter: 7 * time.Second}, min: 7 * time.Second, max: 7 * time.Second},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				if got := test.policy.backoff(test.attempt, test.err); got < test.min || got > test.max {
+					t.Fatalf("backoff(%d) = %v, want between %v and %v", test.attempt, got, test.min, test.max)
+				}
+			}
+		})
+	}
+}
+
+func TestRequestWithRetry(t *testing.T) {
+	tests := []struct {
+		name       string
+		idempotent bool
+		budget     time.Duration
+		statuses   []int
+		retryAfter string
+		wantCalls  int32
+		wantStatus int
+	}{
+		{name: "succeeds first", idempotent: true, statuses: []int{200}, wantCalls: 1, wantStatus: 200},
+		{name: "idempotent retries 500", idempotent: true, statuses: []int{500, 502, 200}, wantCalls: 3, wantStatus: 200},
+		{name: "gives up after max attempts", idempotent: true, statuses: []int{500, 500, 500, 200}, wantCalls: 3, wantStatus: 500},
+		{name: "non idempotent keeps 500", statuses: []int{500, 200}, wantCalls: 1, wantStatus: 500},
+		{name: "non idempotent retries 503", statuses: []int{503, 200}, wantCalls: 2, wantStatus: 200},
+		{name: "rejection not retried", idempotent: true, statuses: []int{400, 200}, wantCalls: 1, wantStatus: 400},
+		{name: "retry after beyond budget", idempotent: true, budget: time.Second, statuses: []int{429, 200}, retryAfter: "10", wantCalls: 1, wantStatus: 429},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var calls int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				call := atomic.AddInt32(&calls, 1)
+				if test.retryAfter != "" {
+					w.Header().Set("Retry-After", test.retryAfter)
+				}
+				w.WriteHeader(test.statuses[call-1])
+			}))
+			defer server.Close()
+
+			policy := RetryPolicy{Idempotent: test.idempotent, MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond, Budget: test.budget}
+			_, err := RequestWithRetry(policy, "", nil, server.URL, "POST")
+
+			status := http.StatusOK
+			var httpErr *HTTPError
+			if errors.As(err, &httpErr) {
+				status = httpErr.StatusCode
+			} else if err != nil {
+				t.Fatalf("RequestWithRetry() error = %v", err)
+			}
+			if status != test.wantStatus {
+				t.Errorf("status = %d, want %d", status, test.wantStatus)
+			}
+			if got := atomic.LoadInt32(&calls); got != test.wantCalls {
+				t.Errorf("calls = %d, want %d", got, test.wantCalls)
+			}
+		})
+	}
+}
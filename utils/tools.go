@@ -2,11 +2,12 @@ package utils
 
 import (
 	"crypto/tls"
-	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptrace"
 	"net/url"
+	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -16,7 +17,16 @@ import (
 //this function constructs http requests using received information
 // It constructs an HTTP request with the given information...
 // ...and calls ExternalRequestTimer to make the reques
+// Non 2xx responses are returned as *HTTPError along with the body. The request is sent once, see RequestWithRetry.
 func Request(request string, headers map[string][]string, urlPath string, method string) (string, error) {
+	return send(request, headers, urlPath, method, false)
+}
+
+// redacted is logged instead of the bodies of requests that carry credentials or tokens.
+const redacted = "[REDACTED]"
+
+// send is Request, logging neither the request nor the response body when redact is set.
+func send(request string, headers map[string][]string, urlPath string, method string, redact bool) (string, error) {
 
 	reqURL, _ := url.Parse(urlPath)
 
@@ -28,9 +38,13 @@ func Request(request string, headers map[string][]string, urlPath string, method
 		Body:   reqBody,
 	}
 
+	logged := request
+	if redact {
+		logged = redacted
+	}
 	res, err := ExternalRequestTimer(req)
 	if err != nil {
-		logrus.Errorf("SEND REQUEST | URL : %s | METHOD : %s | BODY : %s | ERROR : %v", urlPath, method, request, err)
+		logrus.Errorf("SEND REQUEST | URL : %s | METHOD : %s | BODY : %s | ERROR : %v", urlPath, method, logged, err)
 		return "", err
 	}
 
@@ -38,18 +52,26 @@ func Request(request string, headers map[string][]string, urlPath string, method
 	defer res.Body.Close()
 	resbody := string(data)
 
-	logrus.Infof("SEND REQUEST | URL : %s | METHOD : %s | BODY : %s | STATUS : %s | HTTP_CODE : %d | RESPONSE : %s", urlPath, method, request, res.Status, res.StatusCode, resbody)
+	loggedResponse := resbody
+	if redact {
+		loggedResponse = redacted
+	}
+	logrus.Infof("SEND REQUEST | URL : %s | METHOD : %s | BODY : %s | STATUS : %s | HTTP_CODE : %d | RESPONSE : %s", urlPath, method, logged, res.Status, res.StatusCode, loggedResponse)
 
 	if res.StatusCode > 299 || res.StatusCode <= 199 {
-		logrus.Errorf("SEND REQUEST | URL : %s | METHOD : %s | BODY : %s | STATUS : %s | HTTP_CODE : %d", urlPath, method, request, res.Status, res.StatusCode)
-		return resbody, fmt.Errorf("%d", res.StatusCode)
+		logrus.Errorf("SEND REQUEST | URL : %s | METHOD : %s | BODY : %s | STATUS : %s | HTTP_CODE : %d", urlPath, method, logged, res.Status, res.StatusCode)
+		return resbody, &HTTPError{
+			StatusCode: res.StatusCode,
+			Body:       resbody,
+			RetryAfter: parseRetryAfter(res.Header.Get("Retry-After")),
+		}
 	}
 
 	return resbody, nil
 }
 
 //This function takes an HTTP request as input and adds timing information to it using an httptrace.ClientTrace object
-//It then makes the request using the default HTTP transport with the RoundTrip function and returns the response and any errors that occur.
+//It then makes the request through HttpClient, guarded per upstream host, and returns the response and any errors that occur.
 func ExternalRequestTimer(req *http.Request) (*http.Response, error) {
 
 	var start, connect, dns, tlsHandshake time.Time
@@ -106,12 +128,29 @@ ConnectStart: func(network, addr string) {
 	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
 	start = time.Now()
 
-	// NOTE: Below line is to ignore ssl certificate
-	http.DefaultTransport.(*http.Transport).TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
-	res, err := http.DefaultTransport.RoundTrip(req)
+	// NOTE: the request goes through HttpClient for its timeouts, in-flight limit and circuit breaker
+	res, err := guardedDo(req)
 	if err != nil {
 		return res, err
 	}
 	return res, nil
 }
 
+
+// GetEnvInt reads an integer from the environment, falling back to def when unset or invalid.
+func GetEnvInt(key string, def int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return def
+	}
+	return value
+}
+
+// GetEnvDuration reads a duration (e.g. "30s", "5m") from the environment, falling back to def when unset or invalid.
+func GetEnvDuration(key string, def time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return def
+	}
+	return value
+}
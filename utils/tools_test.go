@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestRequestWithRetryRedact(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		fmt.Fprint(w, `{"token": "issued-secret"}`)
+	}))
+	defer server.Close()
+
+	var logs bytes.Buffer
+	out := logrus.StandardLogger().Out
+	logrus.SetOutput(&logs)
+	defer logrus.SetOutput(out)
+
+	tests := []struct {
+		name   string
+		path   string
+		redact bool
+		logged bool
+	}{
+		{name: "logged", path: "/token", redact: false, logged: true},
+		{name: "redacted", path: "/token", redact: true, logged: false},
+		{name: "redacted failure", path: "/fail", redact: true, logged: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			logs.Reset()
+			policy := RetryPolicy{MaxAttempts: 1, Redact: test.redact}
+			response, _ := RequestWithRetry(policy, `{"password": "sent-secret"}`, nil, server.URL+test.path, "POST")
+			if !strings.Contains(response, "issued-secret") {
+				t.Fatalf("response = %q, want the upstream body", response)
+			}
+			for _, secret := range []string{"sent-secret", "issued-secret"} {
+				if got := strings.Contains(logs.String(), secret); got != test.logged {
+					t.Errorf("%s logged = %v, want %v", secret, got, test.logged)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package webhook
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSign(t *testing.T) {
+	// computed independently: HMAC-SHA256 of `1700000000.{"status":"A"}` keyed with whsec_test
+	want := "57efb75052e865bd4fe1ff838d38a4a186f9982b10f13ebebe9ec6d69e32c04e"
+	if got := Sign("whsec_test", 1700000000, []byte(`{"status":"A"}`)); got != want {
+		t.Errorf("Sign() = %s, want %s", got, want)
+	}
+	if got := Header([]string{"whsec_test", "whsec_old"}, 1700000000, []byte(`{"status":"A"}`)); got != fmt.Sprintf("v1=%s,v1=%s", want, Sign("whsec_old", 1700000000, []byte(`{"status":"A"}`))) {
+		t.Errorf("Header() = %s, want one v1 signature per secret", got)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	body := []byte(`{"status":"A"}`)
+	now := time.Now().Unix()
+	timestamp := fmt.Sprintf("%d", now)
+	tests := []struct {
+		name      string
+		signature string
+		timestamp string
+		body      []byte
+		tolerance time.Duration
+		secrets   []string
+		want      error
+	}{
+		{name: "valid", signature: Header([]string{"new"}, now, body), timestamp: timestamp, body: body, tolerance: time.Minute, secrets: []string{"new"}},
+		{name: "signed during rotation", signature: Header([]string{"new", "old"}, now, body), timestamp: timestamp, body: body, tolerance: time.Minute, secrets: []string{"old"}},
+		{name: "partner holds both secrets", signature: Header([]string{"new"}, now, body), timestamp: timestamp, body: body, tolerance: time.Minute, secrets: []string{"old", "new"}},
+		{name: "unknown schemes skipped", signature: "v0=abc, " + Header([]string{"new"}, now, body), timestamp: timestamp, body: body, tolerance: time.Minute, secrets: []string{"new"}},
+		{name: "missing signature", timestamp: timestamp, body: body, secrets: []string{"new"}, want: ErrMissingSignature},
+		{name: "missing timestamp", signature: Header([]string{"new"}, now, body), body: body, secrets: []string{"new"}, want: ErrMissingSignature},
+		{name: "invalid timestamp", signature: Header([]string{"new"}, now, body), timestamp: "yesterday", body: body, secrets: []string{"new"}, want: ErrInvalidTimestamp},
+		{name: "replayed", signature: Header([]string{"new"}, now-600, body), timestamp: fmt.Sprintf("%d", now-600), body: body, tolerance: time.Minute, secrets: []string{"new"}, want: ErrTimestampExpired},
+		{name: "from the future", signature: Header([]string{"new"}, now+600, body), timestamp: fmt.Sprintf("%d", now+600), body: body, tolerance: time.Minute, secrets: []string{"new"}, want: ErrTimestampExpired},
+		{name: "no tolerance", signature: Header([]string{"new"}, now-600, body), timestamp: fmt.Sprintf("%d", now-600), body: body, secrets: []string{"new"}},
+		{name: "wrong secret", signature: Header([]string{"other"}, now, body), timestamp: timestamp, body: body, tolerance: time.Minute, secrets: []string{"new"}, want: ErrSignatureMismatch},
+		{name: "tampered body", signature: Header([]string{"new"}, now, body), timestamp: timestamp, body: []byte(`{"status":"D"}`), tolerance: time.Minute, secrets: []string{"new"}, want: ErrSignatureMismatch},
+		{name: "timestamp moved", signature: Header([]string{"new"}, now, body), timestamp: fmt.Sprintf("%d", now+1), body: body, tolerance: time.Minute, secrets: []string{"new"}, want: ErrSignatureMismatch},
+		{name: "no secrets", signature: Header([]string{"new"}, now, body), timestamp: timestamp, body: body, tolerance: time.Minute, want: ErrSignatureMismatch},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := Verify(test.signature, test.timestamp, test.body, test.tolerance, test.secrets...); !errors.Is(err, test.want) {
+				t.Errorf("Verify() = %v, want %v", err, test.want)
+			}
+		})
+	}
+}